@@ -0,0 +1,182 @@
+package cfg
+
+// Complexity returns the McCabe cyclomatic complexity of the graph, E - N + 2.
+// The panic-exit node counts as the exit node, so panicking adds no more to
+// the complexity than returning.
+func Complexity(cfg *CFG) int {
+	edges, nodes := 0, len(cfg.Nodes)
+	for _, node := range cfg.Nodes {
+		edges += len(node.Edges)
+		if node.Kind == KindPanicExit {
+			nodes--
+		}
+	}
+	return edges - nodes + 2
+}
+
+// Walk visits the nodes reachable from the entry node depth-first, calling fn
+// once on each. The successors of a node are not walked when fn returns false
+// for it, though they may still be reached another way.
+func (c *CFG) Walk(fn func(*CFGNode) bool) {
+	visited := make(map[*CFGNode]bool)
+	stack := []*CFGNode{c.Nodes[0]}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[node] {
+			continue
+		}
+		visited[node] = true
+		if !fn(node) {
+			continue
+		}
+		// Push in reverse so that the first edge is walked first
+		for i := len(node.Edges) - 1; i >= 0; i-- {
+			if succ := node.Edges[i].To; succ != nil && !visited[succ] {
+				stack = append(stack, succ)
+			}
+		}
+	}
+}
+
+// Unreachable returns the statement nodes that can't be reached from the entry
+// node, such as code following an unconditional return.
+func Unreachable(cfg *CFG) []*CFGNode {
+	visited := make(map[*CFGNode]bool)
+	cfg.Walk(func(node *CFGNode) bool {
+		visited[node] = true
+		return true
+	})
+
+	var unreachable []*CFGNode
+	for _, node := range cfg.Nodes {
+		if !visited[node] && node.Kind != KindExit && node.Kind != KindPanicExit {
+			unreachable = append(unreachable, node)
+		}
+	}
+	return unreachable
+}
+
+// Reachable returns a copy of the graph without the nodes Unreachable reports,
+// renumbering the rest.
+func (c *CFG) Reachable() *CFG {
+	pruned := make(map[*CFGNode]bool)
+	for _, node := range Unreachable(c) {
+		pruned[node] = true
+	}
+	var kept []*CFGNode
+	for _, node := range c.Nodes {
+		if !pruned[node] {
+			kept = append(kept, node)
+		}
+	}
+	return c.copyNodes(kept)
+}
+
+// WithoutEntry returns a copy of the graph without its entry node, rooted
+// instead at the node the entry leads to, which becomes the first node. The
+// rest are renumbered after it in their original order.
+func (c *CFG) WithoutEntry() *CFG {
+	root := c.Nodes[0].Edges[0].To
+	nodes := []*CFGNode{root}
+	for _, node := range c.Nodes[1:] {
+		if node != root {
+			nodes = append(nodes, node)
+		}
+	}
+	return c.copyNodes(nodes)
+}
+
+// InReversePostOrder returns a copy of the graph with its nodes renumbered in
+// reverse postorder, roughly the order they run in, so that reading the IDs
+// in turn follows the flow of the function. Unreachable nodes come after the
+// reachable ones, and the panic-exit and exit nodes stay last.
+func (c *CFG) InReversePostOrder() *CFG {
+	placed := make(map[*CFGNode]bool)
+	var nodes []*CFGNode
+	add := func(node *CFGNode) {
+		if !placed[node] && node.Kind != KindExit && node.Kind != KindPanicExit {
+			placed[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	for _, node := range c.ReversePostOrder() {
+		add(node)
+	}
+	for _, node := range c.Nodes {
+		add(node)
+	}
+	for _, node := range c.Nodes {
+		if node.Kind == KindExit || node.Kind == KindPanicExit {
+			nodes = append(nodes, node)
+		}
+	}
+	return c.copyNodes(nodes)
+}
+
+// copyNodes returns a graph of copies of nodes, numbered in the order given,
+// keeping the deferred calls among them.
+func (c *CFG) copyNodes(nodes []*CFGNode) *CFG {
+	g := &CFG{}
+	copies := make(map[*CFGNode]*CFGNode)
+	for _, node := range nodes {
+		copied := *node
+		copied.ID = len(g.Nodes)
+		g.Nodes = append(g.Nodes, &copied)
+		copies[node] = &copied
+	}
+	for _, node := range c.Deferred {
+		if copied := copies[node]; copied != nil {
+			g.Deferred = append(g.Deferred, copied)
+		}
+	}
+	g.linkPreds()
+	return g
+}
+
+// InDegree returns the number of edges into n, so a join point has more than
+// one.
+func (c *CFG) InDegree(n *CFGNode) int {
+	return len(n.Preds)
+}
+
+// OutDegree returns the number of edges out of n, so a branch point has more
+// than one.
+func (c *CFG) OutDegree(n *CFGNode) int {
+	return len(n.Edges)
+}
+
+// GraphStats is a structural summary of a graph.
+type GraphStats struct {
+	Nodes int
+	Edges int
+	// Conditionals counts the if, switch and select nodes.
+	Conditionals int
+	Loops        int
+	// MaxLoopDepth is the nesting depth of the most deeply nested loop.
+	MaxLoopDepth int
+}
+
+// Stats returns the node, edge, conditional and loop counts of the graph.
+func Stats(cfg *CFG) GraphStats {
+	stats := GraphStats{Nodes: len(cfg.Nodes)}
+	for _, node := range cfg.Nodes {
+		stats.Edges += len(node.Edges)
+		kinds := []NodeKind{node.Kind}
+		for _, member := range node.Block {
+			kinds = append(kinds, member.Kind)
+		}
+		for _, kind := range kinds {
+			switch kind {
+			case KindIf, KindSwitch, KindTypeSwitch, KindSelect:
+				stats.Conditionals++
+			}
+		}
+	}
+	loops := Loops(cfg)
+	stats.Loops = len(loops)
+	for _, loop := range loops {
+		stats.MaxLoopDepth = max(stats.MaxLoopDepth, loop.Depth())
+	}
+	return stats
+}
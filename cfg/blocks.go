@@ -0,0 +1,81 @@
+package cfg
+
+import "sort"
+
+// BasicBlocks returns a copy of the graph in which each maximal run of
+// statement nodes with a single way in and a single way out is coalesced into
+// one node of Kind KindBlock. Branch and join points keep nodes of their own, as
+// do the entry and exit, defer statements and statements spawning closures.
+func (c *CFG) BasicBlocks() *CFG {
+	index := make(map[*CFGNode]int)
+	for i, node := range c.Nodes {
+		index[node] = i
+	}
+	mergeable := func(node *CFGNode) bool {
+		switch node.Kind {
+		case KindEntry, KindExit, KindPanicExit, KindDefer:
+			return false
+		case KindIf, KindCond, KindFor, KindRange, KindRangeChan, KindSwitch, KindTypeSwitch, KindSelect:
+			// Branch points keep their kind and label even with a single way out
+			return false
+		}
+		return len(node.Closures) == 0 && len(node.Edges) <= 1
+	}
+	// A node continues a run when it is the only successor of its only predecessor
+	continuesRun := func(node *CFGNode) bool {
+		if !mergeable(node) || len(node.Preds) != 1 {
+			return false
+		}
+		pred := node.Preds[0]
+		return pred != node && mergeable(pred) && len(pred.Edges) == 1
+	}
+
+	blockOf := make(map[*CFGNode]*CFGNode)
+	var runs [][]*CFGNode
+	startRun := func(head *CFGNode) {
+		run := []*CFGNode{head}
+		for last := head; len(last.Edges) == 1; {
+			next := last.Edges[0].To
+			if next == nil || blockOf[next] != nil || next == head || !continuesRun(next) {
+				break
+			}
+			run = append(run, next)
+			last = next
+		}
+		block := &CFGNode{Stmt: head.Stmt, Kind: head.Kind, Closures: head.Closures, NamedResults: head.NamedResults, Meta: head.Meta}
+		if len(run) > 1 {
+			// The members keep their own metadata
+			block.Kind = KindBlock
+			block.Block = run
+			block.Meta = nil
+		}
+		block.Edges = append(block.Edges, run[len(run)-1].Edges...)
+		for _, node := range run {
+			blockOf[node] = block
+		}
+		runs = append(runs, run)
+	}
+	for _, node := range c.Nodes {
+		if !continuesRun(node) {
+			startRun(node)
+		}
+	}
+	// Cycles with no way in from outside have no natural head
+	for _, node := range c.Nodes {
+		if blockOf[node] == nil {
+			startRun(node)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool { return index[runs[i][0]] < index[runs[j][0]] })
+	blocks := &CFG{Nodes: make([]*CFGNode, len(runs))}
+	for i, run := range runs {
+		blocks.Nodes[i] = blockOf[run[0]]
+		blocks.Nodes[i].ID = i
+	}
+	for _, node := range c.Deferred {
+		blocks.Deferred = append(blocks.Deferred, blockOf[node])
+	}
+	blocks.linkPreds()
+	return blocks
+}
@@ -0,0 +1,948 @@
+// Package cfg builds control flow graphs of Go function bodies.
+package cfg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"strings"
+)
+
+// CFGNode is a node of the graph, usually standing for a single statement.
+type CFGNode struct {
+	// ID is the node's index in the graph's Nodes.
+	ID   int
+	Stmt ast.Stmt
+	// Kind is what the node stands for, one of the Kind constants.
+	Kind  NodeKind
+	Edges []*CFGEdge
+	// Preds holds the nodes with an edge to this one, once per edge, in the
+	// order of Nodes.
+	Preds []*CFGNode
+	// Closures holds the graphs of the function literals in a simple
+	// statement, such as the one a go statement spawns, in source order.
+	Closures []*CFG
+	// Block holds the statement nodes a basic-block node coalesces, in order.
+	Block []*CFGNode
+	// NamedResults holds the named results a bare return returns.
+	NamedResults []*ast.Ident
+	// Method is the method a call statement calls, when the graph was built
+	// with Options.Types and the call resolves to one.
+	Method *types.Func
+	// Meta holds data analyses attach to the node, keyed by a name of their
+	// choosing. The builder leaves it nil.
+	Meta map[string]any
+}
+
+// Name returns an identifier for the node that is unique within its graph:
+// "entry", "exit", "panic_exit" or "node" followed by its ID.
+func (n *CFGNode) Name() string {
+	switch n.Kind {
+	case KindEntry, KindExit:
+		return string(n.Kind)
+	case KindPanicExit:
+		return "panic_exit"
+	}
+	return fmt.Sprintf("node%d", n.ID)
+}
+
+// LeavesFunction reports whether the node returns from the function, falls
+// off the end of its body or panics, any of which runs the deferred calls.
+func (n *CFGNode) LeavesFunction() bool {
+	for _, edge := range n.Edges {
+		if edge.Kind == EdgeReturn || edge.Kind == EdgeError || edge.Kind == EdgePanic {
+			return true
+		}
+		if edge.To != nil && edge.To.Kind == KindExit {
+			return true
+		}
+	}
+	return false
+}
+
+// CFGEdge is an edge to the node of Stmt.
+type CFGEdge struct {
+	Stmt ast.Stmt
+	// To is the node of Stmt in the graph holding the edge.
+	To *CFGNode
+	// Kind is what the edge stands for, one of the Edge constants.
+	Kind  EdgeKind
+	Label string
+}
+
+// CFG is the control flow graph of a function body. Nodes starts with the
+// entry node, or the node it leads to once WithoutEntry has removed it, and
+// ends with the exit node, which is preceded by the panic-exit node if the
+// body calls panic. Nodes is indexed by ID and each node's Edges are in the
+// order they were built, so walking them, rather than nodeMap, gives the same
+// order for the same source every time.
+type CFG struct {
+	Nodes []*CFGNode
+	// Deferred holds the defer nodes in the order their calls run when the
+	// function returns, i.e. the most recently registered first.
+	Deferred []*CFGNode
+	nodeMap  map[ast.Stmt]*CFGNode
+}
+
+// Node returns the node of stmt, which edges use to refer to their target, or
+// nil if stmt has no node.
+func (c *CFG) Node(stmt ast.Stmt) *CFGNode {
+	if c.nodeMap == nil {
+		c.nodeMap = make(map[ast.Stmt]*CFGNode, len(c.Nodes))
+		for _, node := range c.Nodes {
+			c.nodeMap[node.Stmt] = node
+		}
+	}
+	return c.nodeMap[stmt]
+}
+
+// DeferredEdge is an edge from a node to the deferred call that runs after it.
+type DeferredEdge struct {
+	From, To *CFGNode
+}
+
+// DeferredEdges returns the edges the deferred calls run along, in LIFO order:
+// from each node leaving the function to the call most recently registered on
+// the way to it, then from each call to the one registered before it. Where
+// branches register different calls there is an edge to each. A defer statement
+// leaving the function gets no edge of the first kind, its own call being the
+// one that runs first.
+func (c *CFG) DeferredEdges() []DeferredEdge {
+	if len(c.Deferred) == 0 {
+		return nil
+	}
+	last := c.lastDeferred()
+	var edges []DeferredEdge
+	for _, node := range c.Nodes {
+		if node.Kind == KindDefer || !node.LeavesFunction() {
+			continue
+		}
+		for _, call := range c.Nodes {
+			if last[node][call] {
+				edges = append(edges, DeferredEdge{From: node, To: call})
+			}
+		}
+	}
+	for _, node := range c.Nodes {
+		if node.Kind != KindDefer {
+			continue
+		}
+		// The calls registered just before are those its predecessors leave
+		// as the most recent, bar its own when it is in a loop
+		before := make(map[*CFGNode]bool)
+		for _, pred := range node.Preds {
+			for call := range last[pred] {
+				if call != node {
+					before[call] = true
+				}
+			}
+		}
+		for _, call := range c.Nodes {
+			if before[call] {
+				edges = append(edges, DeferredEdge{From: node, To: call})
+			}
+		}
+	}
+	return edges
+}
+
+// lastDeferred maps each node to the defer nodes whose call may be the most
+// recently registered once the node has run, following the edges from each
+// defer node until they reach another.
+func (c *CFG) lastDeferred() map[*CFGNode]map[*CFGNode]bool {
+	last := make(map[*CFGNode]map[*CFGNode]bool)
+	for _, node := range c.Deferred {
+		last[node] = map[*CFGNode]bool{node: true}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, node := range c.Nodes {
+			if node.Kind == KindDefer {
+				continue
+			}
+			for _, pred := range node.Preds {
+				for call := range last[pred] {
+					if last[node][call] {
+						continue
+					}
+					if last[node] == nil {
+						last[node] = make(map[*CFGNode]bool)
+					}
+					last[node][call] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return last
+}
+
+// linkPreds points the edges of the graph at their targets and fills in the
+// Preds of every node from them. The edges are copied first, as a node copied
+// from another graph shares its edges.
+func (c *CFG) linkPreds() {
+	for _, node := range c.Nodes {
+		node.Preds = nil
+	}
+	for _, node := range c.Nodes {
+		edges := make([]*CFGEdge, len(node.Edges))
+		for i, edge := range node.Edges {
+			copied := *edge
+			copied.To = c.Node(edge.Stmt)
+			edges[i] = &copied
+			if copied.To != nil {
+				copied.To.Preds = append(copied.To.Preds, node)
+			}
+		}
+		node.Edges = edges
+	}
+}
+
+// BuildCFG builds the control flow graph of a function declaration. It works
+// purely on the AST, so the source the declaration came from is not needed. A
+// declaration without a body, such as one implemented in assembly, gets a graph
+// going straight from the entry to the exit, as an empty body does.
+func BuildCFG(fset *token.FileSet, funcDecl *ast.FuncDecl) (*CFG, error) {
+	return BuildCFGWithOptions(fset, funcDecl, Options{})
+}
+
+// Options changes how BuildCFGWithOptions builds a graph. The zero value
+// builds the same graph as BuildCFG.
+type Options struct {
+	// Types is the type information of the function, whose Types map tells
+	// which ranged expressions are channels and whose Selections map gives
+	// call statements their Method. If nil, channels are judged from the AST
+	// alone.
+	Types *types.Info
+	// ShortCircuit splits && and || conditions of if and for statements into
+	// a "cond" node per operand, each reached only when it is evaluated.
+	ShortCircuit bool
+	// SplitDecls gives each spec of a grouped declaration such as
+	// "var ( a int; b string )" a node of its own, rather than one node for
+	// the group.
+	SplitDecls bool
+	// PruneConstants leaves out the edge into the branch of an if whose
+	// condition is the constant true or false, such as "if false", that can
+	// never be taken, leaving the branch unreachable.
+	PruneConstants bool
+	// MaxDepth is how deeply statements may nest, counting those in function
+	// literals, before building fails with an error. If 0, DefaultMaxDepth is
+	// used.
+	MaxDepth int
+	// Logger receives a message for each statement the builder can't
+	// handle, such as a fallthrough out of the last case of a switch. If nil,
+	// they are dropped.
+	Logger *log.Logger
+}
+
+// DefaultMaxDepth is the nesting depth at which building a graph fails unless
+// Options.MaxDepth says otherwise. It keeps machine-generated or hostile input
+// from exhausting the stack.
+const DefaultMaxDepth = 1000
+
+// BuildCFGWithOptions is BuildCFG with the given options.
+func BuildCFGWithOptions(fset *token.FileSet, funcDecl *ast.FuncDecl, opts Options) (*CFG, error) {
+	body := funcDecl.Body
+	if body == nil {
+		body = &ast.BlockStmt{Lbrace: funcDecl.End(), Rbrace: funcDecl.End()}
+	}
+	guard := &depthGuard{max: opts.MaxDepth}
+	if guard.max <= 0 {
+		guard.max = DefaultMaxDepth
+	}
+	cfg := generateBodyCFG(funcDecl.Type, body, opts, guard)
+	if guard.tooDeep != nil {
+		return nil, fmt.Errorf("%s: statements nested more than %d deep", fset.Position(guard.tooDeep.Pos()), guard.max)
+	}
+	return cfg, nil
+}
+
+// CFGsFromSource parses src as a Go source file and builds the graph of each
+// function declaration in it, in source order.
+func CFGsFromSource(src string) ([]*CFG, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parsing source: %w", err)
+	}
+	var cfgs []*CFG
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		cfg, err := BuildCFG(fset, funcDecl)
+		if err != nil {
+			return nil, err
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// generateBodyCFG builds the graph of a function body, from a synthetic entry
+// node to a synthetic exit node. The function's type tells apart returns of a
+// non-nil error.
+func generateBodyCFG(typ *ast.FuncType, body *ast.BlockStmt, opts Options, guard *depthGuard) *CFG {
+	b := &cfgBuilder{
+		cfg:         &CFG{Nodes: []*CFGNode{}, nodeMap: make(map[ast.Stmt]*CFGNode)},
+		labels:      make(map[string]*CFGNode),
+		stmtLabels:  make(map[ast.Stmt]string),
+		errorResult: -1,
+		opts:        opts,
+		guard:       guard,
+	}
+	// Find the error result, if any, among the flattened results
+	if typ.Results != nil {
+		i := 0
+		for _, field := range typ.Results.List {
+			n := max(len(field.Names), 1)
+			if ident, ok := field.Type.(*ast.Ident); ok && ident.Name == "error" {
+				b.errorResult = i + n - 1
+			}
+			i += n
+			b.namedResults = append(b.namedResults, field.Names...)
+		}
+		b.numResults = i
+	}
+
+	// Create a node for the function entry point
+	entryNode := &CFGNode{Stmt: nil, Kind: KindEntry}
+	b.addNode(entryNode)
+
+	// Collect the labels up front so that forward gotos can be resolved
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// Labels are scoped to their own function
+			return false
+		case *ast.LabeledStmt:
+			b.labels[n.Label.Name] = &CFGNode{Stmt: n, Kind: KindLabel}
+			b.stmtLabels[n.Stmt] = n.Label.Name
+		}
+		return true
+	})
+
+	// Returns and falling off the end of the body both lead to the exit, which
+	// is keyed by an implicit empty statement at the closing brace
+	b.exit = &CFGNode{Stmt: &ast.EmptyStmt{Semicolon: body.Rbrace, Implicit: true}, Kind: KindExit}
+
+	// Chain the statements of the function body, starting from the entry
+	succs := b.createCFGNodes(body.List, []pendingEdge{{from: entryNode, kind: EdgeCall}})
+	linkEdges(succs, b.exit.Stmt)
+	if b.panicExit != nil {
+		b.addNode(b.panicExit)
+	}
+	b.addNode(b.exit)
+
+	// Edges only refer to the statements of their targets, which may come
+	// later, so they are resolved and the predecessors filled in once all
+	// nodes exist
+	b.cfg.linkPreds()
+	return b.cfg
+}
+
+// cfgBuilder holds the state shared while building the graph of one body.
+type cfgBuilder struct {
+	cfg *CFG
+	// labels maps each label in the body to the node of its labeled statement.
+	labels map[string]*CFGNode
+	// stmtLabels maps each labeled statement's inner statement to its label.
+	stmtLabels map[ast.Stmt]string
+	// targets is the stack of enclosing statements break and continue can target.
+	targets []*branchTarget
+	exit    *CFGNode
+	// panicExit is the abnormal exit that panics lead to, created on the
+	// first one.
+	panicExit *CFGNode
+	// errorResult is the index of the function's last error result, or -1.
+	errorResult int
+	numResults  int
+	// namedResults holds the names of the function's results, if named.
+	namedResults []*ast.Ident
+	opts         Options
+	// guard is shared with the builders of the body's function literals.
+	guard *depthGuard
+}
+
+// depthGuard tracks how deeply the statement being built is nested.
+type depthGuard struct {
+	max   int
+	depth int
+	// tooDeep is the first statement found nested deeper than max.
+	tooDeep ast.Stmt
+}
+
+// branchTarget is an enclosing loop, switch or select that break statements,
+// and for loops continue statements, can target.
+type branchTarget struct {
+	stmt  ast.Stmt
+	label string
+	// continueTo is where a continue of a loop goes, and nil for other targets.
+	continueTo ast.Stmt
+	// breaks are the edges leaving stmt through a break statement.
+	breaks []pendingEdge
+}
+
+// pendingEdge is an edge whose target is the statement that follows, which is
+// not known until that statement is built.
+type pendingEdge struct {
+	from  *CFGNode
+	kind  EdgeKind
+	label string
+}
+
+// nextEdge returns the edge for control falling through from node.
+func nextEdge(node *CFGNode) []pendingEdge {
+	return []pendingEdge{{from: node, kind: EdgeSeq}}
+}
+
+// linkEdges resolves each pending edge to stmt.
+func linkEdges(edges []pendingEdge, stmt ast.Stmt) {
+	for _, edge := range edges {
+		edge.from.Edges = append(edge.from.Edges, &CFGEdge{Stmt: stmt, Kind: edge.kind, Label: edge.label})
+	}
+}
+
+// linkLoop resolves the edges leaving a loop body to the loop header.
+func linkLoop(edges []pendingEdge, header ast.Stmt) {
+	for _, edge := range edges {
+		edge.from.Edges = append(edge.from.Edges, &CFGEdge{Stmt: header, Kind: EdgeBack, Label: edge.label})
+	}
+}
+
+// logf passes a message about the input to Options.Logger, if set.
+func (b *cfgBuilder) logf(format string, args ...any) {
+	if b.opts.Logger != nil {
+		b.opts.Logger.Printf(format, args...)
+	}
+}
+
+// returnsError reports whether ret explicitly returns something other than nil
+// as the function's error result. Returning the results of a call as they are
+// only counts when the call makes a new error, as any other may return nil,
+// whether it returns just the error or all of the results.
+func (b *cfgBuilder) returnsError(ret *ast.ReturnStmt) bool {
+	if b.errorResult < 0 {
+		return false
+	}
+	if len(ret.Results) == 1 {
+		if call, ok := ast.Unparen(ret.Results[0]).(*ast.CallExpr); ok {
+			return isNewError(call)
+		}
+	}
+	if len(ret.Results) != b.numResults {
+		return false
+	}
+	ident, ok := ast.Unparen(ret.Results[b.errorResult]).(*ast.Ident)
+	return !ok || ident.Name != "nil"
+}
+
+// isNewError reports whether call is to errors.New or fmt.Errorf.
+func isNewError(call *ast.CallExpr) bool {
+	sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && (pkg.Name == "errors" && sel.Sel.Name == "New" || pkg.Name == "fmt" && sel.Sel.Name == "Errorf")
+}
+
+// isChannel reports whether x, the operand of a range clause, is a channel.
+// Without type information it goes by the AST, recognising a make(chan T) call
+// and variables declared with a channel type or initialised by one.
+func (b *cfgBuilder) isChannel(x ast.Expr) bool {
+	if b.opts.Types != nil {
+		if tv, ok := b.opts.Types.Types[x]; ok && tv.Type != nil {
+			_, ok := tv.Type.Underlying().(*types.Chan)
+			return ok
+		}
+	}
+	switch x := ast.Unparen(x).(type) {
+	case *ast.ChanType:
+		return true
+	case *ast.CallExpr:
+		if ident, ok := x.Fun.(*ast.Ident); ok && ident.Name == "make" && len(x.Args) > 0 {
+			_, ok := x.Args[0].(*ast.ChanType)
+			return ok
+		}
+	case *ast.Ident:
+		if x.Obj == nil {
+			return false
+		}
+		switch decl := x.Obj.Decl.(type) {
+		case *ast.Field:
+			_, ok := decl.Type.(*ast.ChanType)
+			return ok
+		case *ast.ValueSpec:
+			if _, ok := decl.Type.(*ast.ChanType); ok {
+				return true
+			}
+			for i, name := range decl.Names {
+				if name.Obj == x.Obj && i < len(decl.Values) {
+					return b.isChannel(decl.Values[i])
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range decl.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && ident.Obj == x.Obj && len(decl.Lhs) == len(decl.Rhs) {
+					return b.isChannel(decl.Rhs[i])
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isTailCall reports whether ret returns the results of a single call, the
+// last thing the function does.
+func isTailCall(ret *ast.ReturnStmt) bool {
+	if len(ret.Results) != 1 {
+		return false
+	}
+	_, ok := ast.Unparen(ret.Results[0]).(*ast.CallExpr)
+	return ok
+}
+
+// isPanicCall reports whether expr is a call to the panic builtin.
+func isPanicCall(expr ast.Expr) bool {
+	call, ok := ast.Unparen(expr).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := ast.Unparen(call.Fun).(*ast.Ident)
+	return ok && ident.Name == "panic"
+}
+
+// isInfiniteLoop reports whether the condition of loop is missing or the
+// constant true.
+func isInfiniteLoop(loop *ast.ForStmt) bool {
+	if loop.Cond == nil {
+		return true
+	}
+	value, ok := constantBool(loop.Cond)
+	return ok && value
+}
+
+// constantBool folds a condition made of the identifiers true and false and
+// the ! operator, reporting whether it could.
+func constantBool(expr ast.Expr) (value, ok bool) {
+	switch expr := ast.Unparen(expr).(type) {
+	case *ast.Ident:
+		switch expr.Name {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	case *ast.UnaryExpr:
+		if expr.Op == token.NOT {
+			value, ok := constantBool(expr.X)
+			return !value, ok
+		}
+	}
+	return false, false
+}
+
+// newNode creates a node of the given kind for stmt and adds it to the graph.
+func (b *cfgBuilder) newNode(stmt ast.Stmt, kind NodeKind) *CFGNode {
+	node := &CFGNode{Stmt: stmt, Kind: kind}
+	// Function literals get graphs of their own, hung off the simple
+	// statement they appear in. Those in compound statements belong to the
+	// nodes of the statements within.
+	switch stmt.(type) {
+	case *ast.ExprStmt, *ast.AssignStmt, *ast.DeclStmt, *ast.SendStmt, *ast.IncDecStmt,
+		*ast.ReturnStmt, *ast.GoStmt, *ast.DeferStmt:
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			lit, ok := n.(*ast.FuncLit)
+			if ok {
+				node.Closures = append(node.Closures, generateBodyCFG(lit.Type, lit.Body, b.opts, b.guard))
+			}
+			return !ok
+		})
+	}
+	if stmt, ok := stmt.(*ast.ExprStmt); ok {
+		node.Method = b.method(stmt.X)
+	}
+	b.addNode(node)
+	return node
+}
+
+// method returns the method x calls, if x is a call Options.Types resolves to
+// one, or nil.
+func (b *cfgBuilder) method(x ast.Expr) *types.Func {
+	call, ok := x.(*ast.CallExpr)
+	if !ok || b.opts.Types == nil {
+		return nil
+	}
+	fun, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	if sel, ok := b.opts.Types.Selections[fun]; ok && sel.Kind() == types.MethodVal {
+		return sel.Obj().(*types.Func)
+	}
+	return nil
+}
+
+// addNode adds node to the graph, giving it the next sequential ID.
+func (b *cfgBuilder) addNode(node *CFGNode) {
+	node.ID = len(b.cfg.Nodes)
+	b.cfg.Nodes = append(b.cfg.Nodes, node)
+	b.cfg.nodeMap[node.Stmt] = node
+}
+
+// createInitNode gives init, the init statement of an if, for or switch, a
+// node of its own with preds flowing into it, run before the statement's own
+// node. It returns the edge leaving it, or preds if there is no init.
+func (b *cfgBuilder) createInitNode(init ast.Stmt, preds []pendingEdge) []pendingEdge {
+	if init == nil {
+		return preds
+	}
+	node := b.newNode(init, KindInit)
+	linkEdges(preds, init)
+	return nextEdge(node)
+}
+
+// createCFGNodes chains the nodes for a list of statements, with preds flowing
+// into the first. It returns the edges leaving the last.
+func (b *cfgBuilder) createCFGNodes(list []ast.Stmt, preds []pendingEdge) []pendingEdge {
+	for _, stmt := range list {
+		preds = b.createCFGNode(stmt, preds)
+	}
+	return preds
+}
+
+// createCFGNode creates the nodes for stmt, linking preds to the first of
+// them, and returns the edges that continue at whatever statement follows
+// stmt. Unsupported statements are skipped, passing preds straight through.
+func (b *cfgBuilder) createCFGNode(stmt ast.Stmt, preds []pendingEdge) []pendingEdge {
+	// Give up on statements nested too deeply, leaving the error to BuildCFG
+	b.guard.depth++
+	defer func() { b.guard.depth-- }()
+	if b.guard.depth > b.guard.max {
+		if b.guard.tooDeep == nil {
+			b.guard.tooDeep = stmt
+		}
+		return nil
+	}
+	var succs []pendingEdge
+	switch stmt := stmt.(type) {
+	case *ast.ExprStmt:
+		// A call to the panic builtin leaves the function through the
+		// abnormal exit rather than carrying on
+		if isPanicCall(stmt.X) {
+			node := b.newNode(stmt, KindPanic)
+			if b.panicExit == nil {
+				b.panicExit = &CFGNode{Stmt: &ast.EmptyStmt{Semicolon: b.exit.Stmt.Pos(), Implicit: true}, Kind: KindPanicExit}
+			}
+			node.Edges = append(node.Edges, &CFGEdge{Stmt: b.panicExit.Stmt, Kind: EdgePanic})
+			break
+		}
+		succs = nextEdge(b.newNode(stmt, KindExpr))
+	case *ast.AssignStmt:
+		succs = nextEdge(b.newNode(stmt, KindAssign))
+	case *ast.DeclStmt:
+		gen, ok := stmt.Decl.(*ast.GenDecl)
+		if !ok || !b.opts.SplitDecls || len(gen.Specs) < 2 {
+			succs = nextEdge(b.newNode(stmt, KindDecl))
+			break
+		}
+		// Each spec is built as a declaration of its own, in turn
+		for _, spec := range gen.Specs {
+			decl := &ast.DeclStmt{Decl: &ast.GenDecl{TokPos: spec.Pos(), Tok: gen.Tok, Specs: []ast.Spec{spec}}}
+			preds = b.createCFGNode(decl, preds)
+		}
+		return preds
+	case *ast.SendStmt:
+		succs = nextEdge(b.newNode(stmt, KindSend))
+	case *ast.IncDecStmt:
+		succs = nextEdge(b.newNode(stmt, KindIncDec))
+	case *ast.ReturnStmt:
+		node := b.newNode(stmt, KindReturn)
+		if isTailCall(stmt) {
+			node.Kind = KindTailCall
+		}
+		if len(stmt.Results) == 0 {
+			node.NamedResults = b.namedResults
+		}
+		kind := EdgeReturn
+		if b.returnsError(stmt) {
+			kind = EdgeError
+		}
+		node.Edges = append(node.Edges, &CFGEdge{Stmt: b.exit.Stmt, Kind: kind})
+	case *ast.IfStmt:
+		preds = b.createInitNode(stmt.Init, preds)
+		node := b.newNode(stmt, KindIf)
+		trueEdge, falseEdge := b.conditionEdges(node, stmt.Cond)
+		// A constant condition never takes one of the branches, which
+		// pruning leaves without an edge into it and so unreachable
+		if value, ok := constantBool(stmt.Cond); ok && b.opts.PruneConstants {
+			if value {
+				falseEdge = nil
+			} else {
+				trueEdge = nil
+			}
+		}
+		// Create nodes for the if statement's branches
+		succs = b.createCFGNodes(stmt.Body.List, trueEdge)
+		// The false edge leads into the else branch if present, otherwise past the if
+		switch els := stmt.Else.(type) {
+		case nil:
+			succs = append(succs, falseEdge...)
+		case *ast.BlockStmt:
+			succs = append(succs, b.createCFGNodes(els.List, falseEdge)...)
+		case *ast.IfStmt:
+			// An else-if chains as a nested if node with a condition of its own
+			succs = append(succs, b.createCFGNode(els, falseEdge)...)
+		}
+	case *ast.ForStmt:
+		// The init runs once before the condition node, which heads the loop
+		preds = b.createInitNode(stmt.Init, preds)
+		node := b.newNode(stmt, KindFor)
+		// Continue goes to the post statement if there is one, else the condition
+		var continueTo ast.Stmt = stmt
+		if stmt.Post != nil {
+			continueTo = stmt.Post
+		}
+		b.pushTarget(stmt, continueTo)
+		// A missing or constant true condition makes the loop infinite, which
+		// is marked on the edge into the body, or on the self-loop of an empty
+		// body. Such a loop can only be left by a break.
+		enter := []pendingEdge{{from: node, kind: EdgeSeq, label: "infinite"}}
+		if !isInfiniteLoop(stmt) {
+			enter, succs = b.conditionEdges(node, stmt.Cond)
+		}
+		body := b.createCFGNodes(stmt.Body.List, enter)
+		if stmt.Post != nil {
+			body = b.createCFGNode(stmt.Post, body)
+		}
+		linkLoop(body, stmt)
+		succs = append(succs, b.popTarget()...)
+	case *ast.RangeStmt:
+		// Ranging over a channel blocks for each value and ends when the
+		// channel is closed, rather than when a collection is exhausted
+		kind, done := KindRange, "done"
+		if b.isChannel(stmt.X) {
+			kind, done = KindRangeChan, "closed"
+		}
+		node := b.newNode(stmt, kind)
+		// Create nodes for the loop body, whose end loops back to the header
+		b.pushTarget(stmt, stmt)
+		linkLoop(b.createCFGNodes(stmt.Body.List, nextEdge(node)), stmt)
+		succs = append([]pendingEdge{{from: node, kind: EdgeDone, label: done}}, b.popTarget()...)
+	case *ast.SwitchStmt:
+		preds = b.createInitNode(stmt.Init, preds)
+		node := b.newNode(stmt, KindSwitch)
+		b.pushTarget(stmt, nil)
+		succs = b.createCaseNodes(stmt.Body, node)
+		succs = append(succs, b.popTarget()...)
+	case *ast.TypeSwitchStmt:
+		preds = b.createInitNode(stmt.Init, preds)
+		node := b.newNode(stmt, KindTypeSwitch)
+		b.pushTarget(stmt, nil)
+		succs = b.createCaseNodes(stmt.Body, node)
+		succs = append(succs, b.popTarget()...)
+	case *ast.DeferStmt:
+		node := b.newNode(stmt, KindDefer)
+		b.cfg.Deferred = append([]*CFGNode{node}, b.cfg.Deferred...)
+		succs = nextEdge(node)
+	case *ast.GoStmt:
+		succs = nextEdge(b.newNode(stmt, KindGo))
+	case *ast.BlockStmt:
+		// A nested block only scopes its statements, which chain into the
+		// surrounding flow without a node of its own
+		return b.createCFGNodes(stmt.List, preds)
+	case *ast.LabeledStmt:
+		node := b.labels[stmt.Label.Name]
+		b.addNode(node)
+		succs = b.createCFGNode(stmt.Stmt, nextEdge(node))
+	case *ast.BranchStmt:
+		switch stmt.Tok {
+		case token.GOTO:
+			node := b.newNode(stmt, KindGoto)
+			if target, ok := b.labels[stmt.Label.Name]; ok {
+				node.Edges = append(node.Edges, &CFGEdge{Stmt: target.Stmt, Kind: EdgeGoto})
+			}
+		case token.BREAK:
+			node := b.newNode(stmt, KindBreak)
+			// The edge is resolved along with those leaving the target
+			if target := b.findTarget(stmt); target != nil {
+				target.breaks = append(target.breaks, pendingEdge{from: node, kind: EdgeBreak})
+			}
+		case token.CONTINUE:
+			node := b.newNode(stmt, KindContinue)
+			if target := b.findTarget(stmt); target != nil && target.continueTo != nil {
+				node.Edges = append(node.Edges, &CFGEdge{Stmt: target.continueTo, Kind: EdgeContinue})
+			}
+		default:
+			b.logf("unsupported branch statement: %s", stmt.Tok)
+			return preds
+		}
+	case *ast.SelectStmt:
+		node := b.newNode(stmt, KindSelect)
+		b.pushTarget(stmt, nil)
+		// Create a node for each comm clause, labeling the edge with its operation
+		for _, clause := range stmt.Body.List {
+			clause := clause.(*ast.CommClause)
+			commNode := b.newNode(clause, KindComm)
+			node.Edges = append(node.Edges, &CFGEdge{Stmt: clause, Kind: EdgeComm, Label: getCommLabel(clause)})
+			succs = append(succs, b.createCFGNodes(clause.Body, nextEdge(commNode))...)
+		}
+		succs = append(succs, b.popTarget()...)
+	case *ast.EmptyStmt:
+		// A stray semicolon does nothing, so control passes straight through
+		return preds
+	default:
+		b.logf("unsupported statement type: %T", stmt)
+		return preds
+	}
+
+	linkEdges(preds, stmt)
+	return succs
+}
+
+// conditionEdges returns the edges out of node, the header of an if or for
+// statement, for its condition cond being true and being false.
+func (b *cfgBuilder) conditionEdges(node *CFGNode, cond ast.Expr) (trueEdges, falseEdges []pendingEdge) {
+	if b.opts.ShortCircuit && isLogicalExpr(cond) {
+		return b.createCondNodes(cond, nextEdge(node))
+	}
+	return []pendingEdge{{from: node, kind: EdgeTrue, label: "true"}}, []pendingEdge{{from: node, kind: EdgeFalse, label: "false"}}
+}
+
+// createCondNodes creates a "cond" node for each operand of the && and ||
+// operators in cond, entered from preds, and returns the edges for cond being
+// true and being false. The right operand is only entered when the left one
+// doesn't already decide the result.
+func (b *cfgBuilder) createCondNodes(cond ast.Expr, preds []pendingEdge) (trueEdges, falseEdges []pendingEdge) {
+	if expr, ok := ast.Unparen(cond).(*ast.BinaryExpr); ok && (expr.Op == token.LAND || expr.Op == token.LOR) {
+		xTrue, xFalse := b.createCondNodes(expr.X, preds)
+		if expr.Op == token.LAND {
+			yTrue, yFalse := b.createCondNodes(expr.Y, xTrue)
+			return yTrue, append(xFalse, yFalse...)
+		}
+		yTrue, yFalse := b.createCondNodes(expr.Y, xFalse)
+		return append(xTrue, yTrue...), yFalse
+	}
+	// Operands are keyed by a synthetic statement, as nodes are by statement
+	stmt := &ast.ExprStmt{X: cond}
+	node := b.newNode(stmt, KindCond)
+	linkEdges(preds, stmt)
+	return []pendingEdge{{from: node, kind: EdgeTrue, label: "true"}}, []pendingEdge{{from: node, kind: EdgeFalse, label: "false"}}
+}
+
+// isLogicalExpr reports whether expr is an && or || expression.
+func isLogicalExpr(expr ast.Expr) bool {
+	binary, ok := ast.Unparen(expr).(*ast.BinaryExpr)
+	return ok && (binary.Op == token.LAND || binary.Op == token.LOR)
+}
+
+// pushTarget makes stmt the innermost target of break statements and, for a
+// loop, of continue statements, which go to continueTo.
+func (b *cfgBuilder) pushTarget(stmt ast.Stmt, continueTo ast.Stmt) {
+	b.targets = append(b.targets, &branchTarget{stmt: stmt, label: b.stmtLabels[stmt], continueTo: continueTo})
+}
+
+// popTarget removes the innermost target and returns the breaks leaving it.
+func (b *cfgBuilder) popTarget() []pendingEdge {
+	target := b.targets[len(b.targets)-1]
+	b.targets = b.targets[:len(b.targets)-1]
+	return target.breaks
+}
+
+// findTarget resolves a break or continue to its enclosing target: the one
+// carrying its label if it has one, otherwise the innermost loop for continue
+// and the innermost target of any kind for break.
+func (b *cfgBuilder) findTarget(branch *ast.BranchStmt) *branchTarget {
+	for i := len(b.targets) - 1; i >= 0; i-- {
+		target := b.targets[i]
+		if branch.Label != nil {
+			if target.label == branch.Label.Name {
+				return target
+			}
+			continue
+		}
+		if branch.Tok == token.BREAK || target.continueTo != nil {
+			return target
+		}
+	}
+	return nil
+}
+
+// getCaseLabel returns the expressions of a case clause joined by commas, or
+// "default" for the default clause.
+func getCaseLabel(clause *ast.CaseClause) string {
+	if clause.List == nil {
+		return "default"
+	}
+	exprs := make([]string, len(clause.List))
+	for i, expr := range clause.List {
+		exprs[i] = types.ExprString(expr)
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// getCommLabel returns the send or receive operation of a select clause, or
+// "default" for the default clause.
+func getCommLabel(clause *ast.CommClause) string {
+	switch comm := clause.Comm.(type) {
+	case nil:
+		return "default"
+	case *ast.SendStmt:
+		return types.ExprString(comm.Chan) + " <- " + types.ExprString(comm.Value)
+	case *ast.ExprStmt:
+		return types.ExprString(comm.X)
+	case *ast.AssignStmt:
+		lhs := make([]string, len(comm.Lhs))
+		for i, expr := range comm.Lhs {
+			lhs[i] = types.ExprString(expr)
+		}
+		return strings.Join(lhs, ", ") + " " + comm.Tok.String() + " " + types.ExprString(comm.Rhs[0])
+	}
+	return ""
+}
+
+// createCaseNodes creates a node for each case clause of a switch body, with
+// an edge from the switch node labeled with the case expressions, and chains
+// each clause's statements after it. It returns the edges leaving the switch.
+func (b *cfgBuilder) createCaseNodes(body *ast.BlockStmt, switchNode *CFGNode) []pendingEdge {
+	var succs []pendingEdge
+	hasDefault := false
+	for i, clause := range body.List {
+		clause := clause.(*ast.CaseClause)
+		caseNode := b.newNode(clause, KindCase)
+		switchNode.Edges = append(switchNode.Edges, &CFGEdge{Stmt: clause, Kind: EdgeCase, Label: getCaseLabel(clause)})
+		if clause.List == nil {
+			hasDefault = true
+		}
+		list := clause.Body
+		var fallthroughStmt *ast.BranchStmt
+		if n := len(list); n > 0 {
+			if branch, ok := list[n-1].(*ast.BranchStmt); ok && branch.Tok == token.FALLTHROUGH {
+				fallthroughStmt, list = branch, list[:n-1]
+			}
+		}
+		tails := b.createCFGNodes(list, nextEdge(caseNode))
+		if fallthroughStmt == nil {
+			succs = append(succs, tails...)
+			continue
+		}
+		// A fallthrough transfers control to the next case clause
+		ftNode := b.newNode(fallthroughStmt, KindFallthrough)
+		linkEdges(tails, fallthroughStmt)
+		if i+1 == len(body.List) {
+			b.logf("fallthrough in the final case of a switch")
+			continue
+		}
+		ftNode.Edges = append(ftNode.Edges, &CFGEdge{Stmt: body.List[i+1], Kind: EdgeFallthrough})
+	}
+	// Without a default clause, control skips the switch when no case matches
+	if !hasDefault {
+		succs = append(succs, nextEdge(switchNode)...)
+	}
+	return succs
+}
@@ -0,0 +1,2574 @@
+package cfg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// buildTestCFG builds the graph of the first function in src, which is
+// prefixed with a package clause.
+func buildTestCFG(t *testing.T, src string) *CFG {
+	t.Helper()
+	return buildTestCFGWithOptions(t, src, Options{})
+}
+
+// buildTestCFGWithOptions is buildTestCFG building with opts.
+func buildTestCFGWithOptions(t *testing.T, src string, opts Options) *CFG {
+	t.Helper()
+	fset, funcDecl := parseTestFunc(t, src)
+	g, err := BuildCFGWithOptions(fset, funcDecl, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// parseTestFunc returns the first function in src, which is prefixed with a
+// package clause, along with the file set it was parsed into.
+func parseTestFunc(t *testing.T, src string) (*token.FileSet, *ast.FuncDecl) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", "package p\n\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			return fset, funcDecl
+		}
+	}
+	t.Fatal("no function in source")
+	return nil, nil
+}
+
+// dumpTestCFG returns the DumpText form of g.
+func dumpTestCFG(t *testing.T, g *CFG) string {
+	t.Helper()
+	var b strings.Builder
+	if err := DumpText(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestDeferredEdges(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			"fall off",
+			"func f() {\n\tdefer a()\n\tdefer b()\n\tx()\n}",
+			[]string{"node3 -> node2", "node2 -> node1"},
+		},
+		{
+			"return and fall off",
+			"func f(c bool) {\n\tdefer a()\n\tif c {\n\t\treturn\n\t}\n\tx()\n}",
+			[]string{"node3 -> node1", "node4 -> node1"},
+		},
+		{
+			"panic",
+			"func f() {\n\tdefer a()\n\tpanic(1)\n}",
+			[]string{"node2 -> node1"},
+		},
+		{
+			"defer last",
+			"func f() {\n\tdefer a()\n\tdefer b()\n}",
+			[]string{"node2 -> node1"},
+		},
+		{
+			"return before defer",
+			"func f(c bool) {\n\tif c {\n\t\treturn\n\t}\n\tdefer a()\n\tx()\n}",
+			[]string{"node4 -> node3"},
+		},
+		{
+			"defer in branches",
+			"func f(c bool) {\n\tif c {\n\t\tdefer a()\n\t} else {\n\t\tdefer b()\n\t}\n\tx()\n}",
+			[]string{"node4 -> node2", "node4 -> node3"},
+		},
+		{
+			"defer in loop",
+			"func f() {\n\tdefer a()\n\tfor i := 0; i < 3; i++ {\n\t\tdefer b()\n\t}\n}",
+			[]string{"node3 -> node1", "node3 -> node4", "node4 -> node1"},
+		},
+		{"no defers", "func f() {\n\tx()\n}", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, edge := range buildTestCFG(t, tt.src).DeferredEdges() {
+				got = append(got, fmt.Sprintf("%s -> %s", edge.From.Name(), edge.To.Name()))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeavesFunction(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\treturn\n\t}\n\tx()\n}")
+	var got []string
+	for _, node := range g.Nodes {
+		if node.LeavesFunction() {
+			got = append(got, node.Name())
+		}
+	}
+	if want := "node2 node3"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"empty", "func f() {}", 1},
+		{"if", "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n}", 2},
+		{"return", "func f(c bool) {\n\tif c {\n\t\treturn\n\t}\n\tx()\n}", 2},
+		{"panic", "func f(c bool) {\n\tif c {\n\t\tpanic(\"x\")\n\t}\n\tx()\n}", 2},
+		{"loop", "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\tif i > 2 {\n\t\t\tbreak\n\t\t}\n\t}\n}", 3},
+		{"straight line", "func f() {\n\ta()\n\tb()\n\tc()\n}", 1},
+		{"loop and two ifs", "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\tif i > 2 {\n\t\t\ta()\n\t\t}\n\t\tif i > 4 {\n\t\t\tb()\n\t\t}\n\t}\n}", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Complexity(buildTestCFG(t, tt.src)); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPanicExit(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\tpanic(\"x\")\n\t}\n\tx()\n}")
+	want := `0 entry
+1 if c
+2 panic panic("x")
+3 expr x()
+4 panic-exit panic
+5 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 4 panic
+3 -> 5 seq
+`
+	if got := dumpTestCFG(t, g); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReturnsError(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{"nil", "func f() error {\n\treturn nil\n}", false},
+		{"variable", "func f(err error) error {\n\treturn err\n}", true},
+		{"results and nil", "func f() (int, error) {\n\treturn 1, nil\n}", false},
+		{"results and error", "func f(err error) (int, error) {\n\treturn 0, err\n}", true},
+		{"pass-through error", "func f() error {\n\treturn h()\n}", false},
+		{"pass-through results", "func f() (int, error) {\n\treturn h()\n}", false},
+		{"new error", "func f() error {\n\treturn errors.New(\"x\")\n}", true},
+		{"no error result", "func f() int {\n\treturn h()\n}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := buildTestCFG(t, tt.src)
+			got := false
+			for _, edge := range g.Nodes[1].Edges {
+				got = got || edge.Kind == EdgeError
+			}
+			if got != tt.want {
+				t.Errorf("got error edge %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeLabelMethod(t *testing.T) {
+	src := "package p\n\ntype T struct{}\n\nfunc (T) M() {}\n\nfunc f(t T) {\n\tt.M()\n\tprintln()\n}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Selections: make(map[*ast.SelectorExpr]*types.Selection)}
+	if _, err := new(types.Config).Check("p", fset, []*ast.File{file}, info); err != nil {
+		t.Fatal(err)
+	}
+	g, err := BuildCFGWithOptions(fset, file.Decls[2].(*ast.FuncDecl), Options{Types: info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := NodeLabel(g.Nodes[1], fset), "t.M()\n(p.T).M"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := NodeLabel(g.Nodes[2], fset), "println()"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBlockLabel(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t\tb := 1\n\t\t_ = b\n\t}\n}").BasicBlocks()
+	fset := token.NewFileSet()
+	want := "a()\nb := 1\n_ = b"
+	if got := NodeLabel(g.Nodes[2], fset); got != want {
+		t.Errorf("NodeLabel: got %q, want %q", got, want)
+	}
+	got := BlockLabel(g.Nodes[2], func(member *CFGNode) string {
+		return string(member.Kind)
+	})
+	if want := "expr\nassign\nassign"; got != want {
+		t.Errorf("BlockLabel: got %q, want %q", got, want)
+	}
+}
+
+func TestConditionLabels(t *testing.T) {
+	g := buildTestCFG(t, "func f(n int) {\n\tif x := g(); x > 0 { // positive\n\t\ta()\n\t}\n\tfor n > 0 {\n\t\tn--\n\t}\n\tswitch y := n; y {\n\tcase 1:\n\t}\n}")
+	want := `0 entry
+1 init x := g()
+2 if x > 0
+3 expr a()
+4 for n > 0
+5 incdec n--
+6 init y := n
+7 switch y
+8 case case 1:
+9 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 true
+2 -> 4 false
+3 -> 4 seq
+4 -> 5 true
+4 -> 6 false
+5 -> 4 back
+6 -> 7 seq
+7 -> 8 case
+7 -> 9 seq
+8 -> 9 seq
+`
+	if got := dumpTestCFG(t, g); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := NodeLabel(g.Nodes[2], token.NewFileSet()); got != "x > 0" {
+		t.Errorf("if label: got %q, want %q", got, "x > 0")
+	}
+}
+
+func TestReversePostOrder(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t}\n\tfor i := 0; i < 3; i++ {\n\t\td()\n\t}\n}")
+	fset := token.NewFileSet()
+	var got []string
+	for _, node := range g.InReversePostOrder().Nodes {
+		got = append(got, flatLabel(node, fset))
+	}
+	want := []string{"", "c", "a()", "b()", "i := 0", "i < 3", "d()", "i++", ""}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The order must still put each node after its immediate dominator
+	idom := Dominators(g)
+	index := make(map[*CFGNode]int)
+	for i, node := range g.ReversePostOrder() {
+		index[node] = i
+	}
+	for node, dom := range idom {
+		if dom != nil && node != dom && index[dom] >= index[node] {
+			t.Errorf("%s comes before its dominator %s", node.Name(), dom.Name())
+		}
+	}
+	loops := Loops(g)
+	if len(loops) != 1 || flatLabel(loops[0].Header, fset) != "i < 3" {
+		t.Errorf("got %d loops, want 1 headed by the for", len(loops))
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		node int
+		want string
+	}{
+		{"dead assignment", "func f() {\n\tx := 1\n\tx = 2\n\t_ = x\n}", 2, ""},
+		{"use", "func f() {\n\tx := 1\n\tx = 2\n\t_ = x\n}", 3, "x"},
+		{"closure locals", "func f() {\n\tg := func() {\n\t\tw := 2\n\t\t_ = w\n\t}\n\tg()\n}", 0, ""},
+		{"closure free variable", "func f() {\n\tx := 1\n\tg := func() {\n\t\t_ = x\n\t}\n\tg()\n}", 2, "x"},
+		{"closure parameter", "func f() {\n\tg := func(p int) {\n\t\t_ = p\n\t}\n\tg(1)\n}", 1, ""},
+		{"if init", "func f() {\n\tif x := 1; x > 0 {\n\t}\n}", 2, "x"},
+		{"bare return", "func f() (n int) {\n\tn = 1\n\treturn\n}", 2, "n"},
+		{"after last use", "func f() {\n\tx := 1\n\tuse(x)\n\ty := 2\n\tuse(y)\n}", 3, ""},
+		{"loop", "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t}\n}", 2, "i n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := buildTestCFG(t, tt.src)
+			live := Liveness(g)[g.Nodes[tt.node]]
+			var got []string
+			for name := range live {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCFG(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "switch",
+			src: `func f(x int) {
+	switch x {
+	case 1:
+		a()
+		fallthrough
+	case 2, 3:
+		b()
+	default:
+		c()
+	}
+	d()
+}`,
+			want: `0 entry
+1 switch x
+2 case case 1:
+3 expr a()
+4 fallthrough fallthrough
+5 case case 2, 3:
+6 expr b()
+7 case default:
+8 expr c()
+9 expr d()
+10 exit
+0 -> 1 call
+1 -> 2 case
+1 -> 5 case
+1 -> 7 case
+2 -> 3 seq
+3 -> 4 seq
+4 -> 5 fallthrough
+5 -> 6 seq
+6 -> 9 seq
+7 -> 8 seq
+8 -> 9 seq
+9 -> 10 seq
+`,
+		},
+		{
+			name: "type switch",
+			src: `func f(x any) {
+	switch v := x.(type) {
+	case int, string:
+		a(v)
+	case nil:
+	default:
+		b()
+	}
+}`,
+			want: `0 entry
+1 typeswitch v := x.(type)
+2 case case int, string:
+3 expr a(v)
+4 case case nil:
+5 case default:
+6 expr b()
+7 exit
+0 -> 1 call
+1 -> 2 case
+1 -> 4 case
+1 -> 5 case
+2 -> 3 seq
+3 -> 7 seq
+4 -> 7 seq
+5 -> 6 seq
+6 -> 7 seq
+`,
+		},
+		{
+			name: "select",
+			src: `func f(a, b chan int) {
+	select {
+	case v := <-a:
+		c(v)
+	case <-b:
+	default:
+		d()
+	}
+}`,
+			want: `0 entry
+1 select select
+2 comm case v := <-a:
+3 expr c(v)
+4 comm case <-b:
+5 comm default:
+6 expr d()
+7 exit
+0 -> 1 call
+1 -> 2 comm
+1 -> 4 comm
+1 -> 5 comm
+2 -> 3 seq
+3 -> 7 seq
+4 -> 7 seq
+5 -> 6 seq
+6 -> 7 seq
+`,
+		},
+		{
+			name: "go",
+			src: `func f() {
+	go work()
+	go func() {
+		a()
+	}()
+}`,
+			want: `0 entry
+1 go go work()
+2 go go func() {…}()
+3 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+`,
+		},
+		{
+			name: "goto",
+			src: `func f(n int) {
+loop:
+	n--
+	if n > 0 {
+		goto loop
+	}
+	goto end
+	a()
+end:
+	b()
+}`,
+			want: `0 entry
+1 label loop:
+2 incdec n--
+3 if n > 0
+4 goto goto loop
+5 goto goto end
+6 expr a()
+7 label end:
+8 expr b()
+9 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 true
+3 -> 5 false
+4 -> 1 goto
+5 -> 7 goto
+6 -> 7 seq
+7 -> 8 seq
+8 -> 9 seq
+`,
+		},
+		{
+			name: "break and continue",
+			src: `func f(n int) {
+outer:
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if j > i {
+				continue outer
+			}
+			if j == 3 {
+				break
+			}
+		}
+	}
+}`,
+			want: `0 entry
+1 label outer:
+2 init i := 0
+3 for i < n
+4 init j := 0
+5 for j < n
+6 if j > i
+7 continue continue outer
+8 if j == 3
+9 break break
+10 incdec j++
+11 incdec i++
+12 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 true
+3 -> 12 false
+4 -> 5 seq
+5 -> 6 true
+5 -> 11 false
+6 -> 7 true
+6 -> 8 false
+7 -> 11 continue
+8 -> 9 true
+8 -> 10 false
+9 -> 11 break
+10 -> 5 back
+11 -> 3 back
+`,
+		},
+		{
+			name: "sequence",
+			src: `func f() {
+	a()
+	b()
+	c()
+}`,
+			want: `0 entry
+1 expr a()
+2 expr b()
+3 expr c()
+4 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 seq
+`,
+		},
+		{
+			name: "two returns",
+			src: `func f(c bool) int {
+	if c {
+		return 1
+	}
+	return 2
+}`,
+			want: `0 entry
+1 if c
+2 return return 1
+3 return return 2
+4 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 4 return
+3 -> 4 return
+`,
+		},
+		{
+			name: "else if",
+			src: `func f(x int) {
+	if x > 0 {
+		a()
+	} else if x < 0 {
+		b()
+	} else {
+		c()
+	}
+}`,
+			want: `0 entry
+1 if x > 0
+2 expr a()
+3 if x < 0
+4 expr b()
+5 expr c()
+6 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 6 seq
+3 -> 4 true
+3 -> 5 false
+4 -> 6 seq
+5 -> 6 seq
+`,
+		},
+		{
+			name: "for",
+			src: `func f(n int) {
+	for i := 0; i < n; i++ {
+		a()
+	}
+	for {
+		b()
+	}
+}`,
+			want: `0 entry
+1 init i := 0
+2 for i < n
+3 expr a()
+4 incdec i++
+5 for for
+6 expr b()
+7 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 true
+2 -> 5 false
+3 -> 4 seq
+4 -> 2 back
+5 -> 6 seq
+6 -> 5 back
+`,
+		},
+		{
+			name: "range",
+			src: `func f(m map[string]int) {
+	for k, v := range m {
+		use(k, v)
+	}
+	a()
+}`,
+			want: `0 entry
+1 range for k, v := range m
+2 expr use(k, v)
+3 expr a()
+4 exit
+0 -> 1 call
+1 -> 2 seq
+1 -> 3 done
+2 -> 1 back
+3 -> 4 seq
+`,
+		},
+		{
+			name: "assignments",
+			src: `func f(a, b int) {
+	x := 1
+	a, b = b, a
+	x += a
+}`,
+			want: `0 entry
+1 assign x := 1
+2 assign a, b = b, a
+3 assign x += a
+4 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 seq
+`,
+		},
+		{
+			name: "declarations",
+			src: `func f() {
+	var x int
+	const y = 2
+	var a, b int
+	type t struct{}
+}`,
+			want: `0 entry
+1 decl var x int
+2 decl const y = 2
+3 decl var a, b int
+4 decl type t struct{}
+5 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 seq
+4 -> 5 seq
+`,
+		},
+		{
+			name: "send",
+			src: `func f(ch chan int, v int) {
+	ch <- v
+	close(ch)
+}`,
+			want: `0 entry
+1 send ch <- v
+2 expr close(ch)
+3 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+`,
+		},
+		{
+			name: "inc and dec",
+			src: `func f(n int) {
+	n--
+	for i := 0; i < n; i++ {
+		a()
+	}
+}`,
+			want: `0 entry
+1 incdec n--
+2 init i := 0
+3 for i < n
+4 expr a()
+5 incdec i++
+6 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 true
+3 -> 6 false
+4 -> 5 seq
+5 -> 3 back
+`,
+		},
+		{
+			name: "infinite for",
+			src: `func f() {
+	for {
+		a()
+	}
+}`,
+			want: `0 entry
+1 for for
+2 expr a()
+3 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 1 back
+`,
+		},
+		{
+			name: "for true",
+			src: `func f() {
+	for true {
+		a()
+	}
+	b()
+}`,
+			want: `0 entry
+1 for true
+2 expr a()
+3 expr b()
+4 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 1 back
+3 -> 4 seq
+`,
+		},
+		{
+			name: "nested block",
+			src: `func f() {
+	a()
+	{
+		x := 1
+		b(x)
+	}
+	c()
+}`,
+			want: `0 entry
+1 expr a()
+2 assign x := 1
+3 expr b(x)
+4 expr c()
+5 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+3 -> 4 seq
+4 -> 5 seq
+`,
+		},
+		{
+			name: "if init",
+			src: `func f() {
+	if x := g(); x > 0 {
+		a(x)
+	} else {
+		b(x)
+	}
+}`,
+			want: `0 entry
+1 init x := g()
+2 if x > 0
+3 expr a(x)
+4 expr b(x)
+5 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 true
+2 -> 4 false
+3 -> 5 seq
+4 -> 5 seq
+`,
+		},
+		{
+			name: "type switch init",
+			src: `func f() {
+	switch v := g(); x := v.(type) {
+	case int:
+		a(x)
+	}
+}`,
+			want: `0 entry
+1 init v := g()
+2 typeswitch x := v.(type)
+3 case case int:
+4 expr a(x)
+5 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 case
+2 -> 5 seq
+3 -> 4 seq
+4 -> 5 seq
+`,
+		},
+		{
+			name: "empty body",
+			src:  `func f() {}`,
+			want: `0 entry
+1 exit
+0 -> 1 call
+`,
+		},
+		{
+			name: "no body",
+			src:  `func f()`,
+			want: `0 entry
+1 exit
+0 -> 1 call
+`,
+		},
+		{
+			name: "else join",
+			src: `func f(c bool) {
+	if c {
+		return
+	} else {
+		a()
+	}
+	b()
+}`,
+			want: `0 entry
+1 if c
+2 return return
+3 expr a()
+4 expr b()
+5 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 5 return
+3 -> 4 seq
+4 -> 5 seq
+`,
+		},
+		{
+			name: "else join after returning else",
+			src: `func f(c bool) {
+	if c {
+		a()
+	} else {
+		return
+	}
+	b()
+}`,
+			want: `0 entry
+1 if c
+2 expr a()
+3 return return
+4 expr b()
+5 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 4 seq
+3 -> 5 return
+4 -> 5 seq
+`,
+		},
+		{
+			name: "break in switch in loop",
+			src: `func f(m []int) {
+	for _, v := range m {
+		switch v {
+		case 1:
+			break
+		}
+		a()
+	}
+}`,
+			want: `0 entry
+1 range for _, v := range m
+2 switch v
+3 case case 1:
+4 break break
+5 expr a()
+6 exit
+0 -> 1 call
+1 -> 2 seq
+1 -> 6 done
+2 -> 3 case
+2 -> 5 seq
+3 -> 4 seq
+4 -> 5 break
+5 -> 1 back
+`,
+		},
+		{
+			name: "edge kinds",
+			src: `func f(n int) int {
+	for i := 0; i < n; i++ {
+		if i == 1 {
+			continue
+		}
+		if i == 2 {
+			break
+		}
+		a()
+	}
+	return n
+}`,
+			want: `0 entry
+1 init i := 0
+2 for i < n
+3 if i == 1
+4 continue continue
+5 if i == 2
+6 break break
+7 expr a()
+8 incdec i++
+9 return return n
+10 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 true
+2 -> 9 false
+3 -> 4 true
+3 -> 5 false
+4 -> 8 continue
+5 -> 6 true
+5 -> 7 false
+6 -> 9 break
+7 -> 8 seq
+8 -> 2 back
+9 -> 10 return
+`,
+		},
+		{
+			name: "labeled break",
+			src: `func f(m [][]int) {
+Outer:
+	for _, row := range m {
+		for _, v := range row {
+			if v < 0 {
+				break Outer
+			}
+		}
+		a()
+	}
+	b()
+}`,
+			want: `0 entry
+1 label Outer:
+2 range for _, row := range m
+3 range for _, v := range row
+4 if v < 0
+5 break break Outer
+6 expr a()
+7 expr b()
+8 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 seq
+2 -> 7 done
+3 -> 4 seq
+3 -> 6 done
+4 -> 5 true
+4 -> 3 back
+5 -> 7 break
+6 -> 2 back
+7 -> 8 seq
+`,
+		},
+		{
+			name: "return in nested if",
+			src: `func f(a, b bool) error {
+	if a {
+		if b {
+			return errors.New("b")
+		}
+		x()
+	}
+	return nil
+}`,
+			want: `0 entry
+1 if a
+2 if b
+3 tailcall return errors.New("b")
+4 expr x()
+5 return return nil
+6 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 5 false
+2 -> 3 true
+2 -> 4 false
+3 -> 6 error
+4 -> 5 seq
+5 -> 6 return
+`,
+		},
+		{
+			name: "loop with break in case",
+			src: `func f(x int, m []int) {
+	switch x {
+	case 1:
+		for _, v := range m {
+			if v < 0 {
+				return
+			}
+			break
+		}
+		a()
+	default:
+		b()
+	}
+	c()
+}`,
+			want: `0 entry
+1 switch x
+2 case case 1:
+3 range for _, v := range m
+4 if v < 0
+5 return return
+6 break break
+7 expr a()
+8 case default:
+9 expr b()
+10 expr c()
+11 exit
+0 -> 1 call
+1 -> 2 case
+1 -> 8 case
+2 -> 3 seq
+3 -> 4 seq
+3 -> 7 done
+4 -> 5 true
+4 -> 6 false
+5 -> 11 return
+6 -> 7 break
+7 -> 10 seq
+8 -> 9 seq
+9 -> 10 seq
+10 -> 11 seq
+`,
+		},
+		{
+			name: "goto out of an if",
+			src: `func f(c bool) {
+	if c {
+		goto done
+	}
+	a()
+done:
+	b()
+}`,
+			want: `0 entry
+1 if c
+2 goto goto done
+3 expr a()
+4 label done:
+5 expr b()
+6 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 4 goto
+3 -> 4 seq
+4 -> 5 seq
+5 -> 6 seq
+`,
+		},
+		{
+			name: "goto into a loop body",
+			src: `func f(n int) {
+	goto inside
+	for n > 0 {
+	inside:
+		n--
+	}
+}`,
+			want: `0 entry
+1 goto goto inside
+2 for n > 0
+3 label inside:
+4 incdec n--
+5 exit
+0 -> 1 call
+1 -> 3 goto
+2 -> 3 true
+2 -> 5 false
+3 -> 4 seq
+4 -> 2 back
+`,
+		},
+		{
+			name: "else block and else if",
+			src: `func f(x int) {
+	if x > 0 {
+		a()
+	} else if x < 0 {
+		b()
+	} else {
+		c()
+		d()
+	}
+	e()
+}`,
+			want: `0 entry
+1 if x > 0
+2 expr a()
+3 if x < 0
+4 expr b()
+5 expr c()
+6 expr d()
+7 expr e()
+8 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 7 seq
+3 -> 4 true
+3 -> 5 false
+4 -> 7 seq
+5 -> 6 seq
+6 -> 7 seq
+7 -> 8 seq
+`,
+		},
+		{
+			name: "else if with init",
+			src: `func f(x int) {
+	if x > 0 {
+		a()
+	} else if y := g(); y {
+		b()
+	}
+}`,
+			want: `0 entry
+1 if x > 0
+2 expr a()
+3 init y := g()
+4 if y
+5 expr b()
+6 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 6 seq
+3 -> 4 seq
+4 -> 5 true
+4 -> 6 false
+5 -> 6 seq
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dumpTestCFG(t, buildTestCFG(t, tt.src)); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEdgeLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		node int
+		want []string
+	}{
+		{
+			name: "select",
+			src:  "func f(a, b chan int) {\n\tselect {\n\tcase v := <-a:\n\tcase <-b:\n\tdefault:\n\t}\n}",
+			node: 1,
+			want: []string{"v := <-a", "<-b", "default"},
+		},
+		{
+			name: "if else",
+			src:  "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t}\n}",
+			node: 1,
+			want: []string{"true", "false"},
+		},
+		{
+			name: "if without else",
+			src:  "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n\tb()\n}",
+			node: 1,
+			want: []string{"true", "false"},
+		},
+		{
+			name: "range",
+			src:  "func f(m map[string]int) {\n\tfor k, v := range m {\n\t\tuse(k, v)\n\t}\n}",
+			node: 1,
+			want: []string{"", "done"},
+		},
+		{
+			name: "infinite for",
+			src:  "func f() {\n\tfor {\n\t\ta()\n\t}\n}",
+			node: 1,
+			want: []string{"infinite"},
+		},
+		{
+			name: "for true",
+			src:  "func f() {\n\tfor true {\n\t\ta()\n\t}\n}",
+			node: 1,
+			want: []string{"infinite"},
+		},
+		{
+			name: "switch",
+			src:  "func f(x int) {\n\tswitch x {\n\tcase 1, 2:\n\t\ta()\n\tdefault:\n\t\tb()\n\t}\n}",
+			node: 1,
+			want: []string{"1, 2", "default"},
+		},
+		{
+			name: "switch without default",
+			src:  "func f(x int) {\n\tswitch x {\n\tcase 1:\n\t\ta()\n\tcase 2, 3:\n\t}\n}",
+			node: 1,
+			want: []string{"1", "2, 3", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, edge := range buildTestCFG(t, tt.src).Nodes[tt.node].Edges {
+				got = append(got, edge.Label)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDOTDeferred(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tdefer a()\n\tdefer b()\n\tx()\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`node1 [label="defer a()", shape="box", style="dashed"];`,
+		`node3 -> node2 [style="dashed"];`,
+		`node2 -> node1 [style="dashed"];`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		node int
+		want []string
+	}{
+		{
+			name: "go",
+			src:  "func f() {\n\tgo work()\n\tgo func() {\n\t\ta()\n\t}()\n}",
+			node: 2,
+			want: []string{"0 entry\n1 expr a()\n2 exit\n0 -> 1 call\n1 -> 2 seq\n"},
+		},
+		{
+			name: "go call",
+			src:  "func f() {\n\tgo work()\n}",
+			node: 1,
+		},
+		{
+			// The closure returns its own results, not the function's
+			name: "bare return",
+			src:  "func f() (n int) {\n\tg := func() {\n\t\treturn\n\t}\n\tg()\n\treturn\n}",
+			node: 1,
+			want: []string{"0 entry\n1 return return\n2 exit\n0 -> 1 call\n1 -> 2 return\n"},
+		},
+		{
+			// The closure's returns leave the closure, by its own signature
+			name: "returns",
+			src:  "func f(c bool) int {\n\tg := func() error {\n\t\tif c {\n\t\t\treturn errors.New(\"x\")\n\t\t}\n\t\treturn nil\n\t}\n\tg()\n\treturn 1\n}",
+			node: 1,
+			want: []string{"0 entry\n1 if c\n2 tailcall return errors.New(\"x\")\n3 return return nil\n4 exit\n0 -> 1 call\n1 -> 2 true\n1 -> 3 false\n2 -> 4 error\n3 -> 4 return\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, closure := range buildTestCFG(t, tt.src).Nodes[tt.node].Closures {
+				got = append(got, dumpTestCFG(t, closure))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestBuildCFGNodes(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", "package p\n\nfunc f() {\n\ta()\n\tb()\n}", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcDecl := file.Decls[0].(*ast.FuncDecl)
+	g, err := BuildCFG(fset, funcDecl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []NodeKind
+	for _, node := range g.Nodes {
+		kinds = append(kinds, node.Kind)
+	}
+	if want := []NodeKind{KindEntry, KindExpr, KindExpr, KindExit}; fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Fatalf("got kinds %v, want %v", kinds, want)
+	}
+	for i, stmt := range funcDecl.Body.List {
+		if node := g.Nodes[i+1]; node.Stmt != stmt || g.Node(stmt) != node {
+			t.Errorf("node %d is not the node of statement %d", i+1, i)
+		}
+	}
+	if to := g.Nodes[1].Edges[0].To; to != g.Nodes[2] {
+		t.Errorf("a() leads to %v, want b()", to)
+	}
+}
+
+func TestCFGsFromSource(t *testing.T) {
+	cfgs, err := CFGsFromSource("package p\n\nfunc f() { a() }\n\nvar x int\n\nfunc g() {}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("got %d graphs, want 2", len(cfgs))
+	}
+	if n := len(cfgs[0].Nodes); n != 3 {
+		t.Errorf("f has %d nodes, want 3", n)
+	}
+	if n := len(cfgs[1].Nodes); n != 2 {
+		t.Errorf("g has %d nodes, want 2", n)
+	}
+}
+
+func TestCFGsFromSourceInvalid(t *testing.T) {
+	_, err := CFGsFromSource("package p\n\nfunc f() {\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "parsing source: source.go:") {
+		t.Errorf("got error %v, want a parse error", err)
+	}
+}
+
+func TestEscapeDOTLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`a()`, `a()`},
+		{`"a"`, `\"a\"`},
+		{`a\b`, `a\\b`},
+		{"a\nb", `a\nb`},
+	}
+	for _, tt := range tests {
+		if got := escapeDOTLabel(tt.in); got != tt.want {
+			t.Errorf("escapeDOTLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteDOTQuotedLabel(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tfmt.Println(\"a\\\"b\")\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	if want := `node1 [label="fmt.Println(\"a\\\"b\")", shape="box"];`; !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in\n%s", want, b.String())
+	}
+}
+
+func TestWriteDOTComplexity(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "  // complexity: 2\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in\n%s", want, b.String())
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"none", "func f(c bool) {\n\tif c {\n\t\treturn\n\t}\n\ta()\n}", nil},
+		{"after return", "func f() {\n\treturn\n\ta()\n\tb()\n}", []string{"a()", "b()"}},
+		{"after return in block", "func f(c bool) {\n\tif c {\n\t\treturn\n\t\ta()\n\t}\n\tb()\n}", []string{"a()"}},
+		{"after break", "func f() {\n\tfor {\n\t\tbreak\n\t\ta()\n\t}\n}", []string{"a()"}},
+		{"after continue", "func f(m []int) {\n\tfor range m {\n\t\tcontinue\n\t\ta()\n\t}\n}", []string{"a()"}},
+		{"if false", "func f() {\n\tif false {\n\t\tx()\n\t}\n\ty()\n}", []string{"x()"}},
+		{"if true", "func f() {\n\tif true {\n\t\tx()\n\t} else {\n\t\tz()\n\t}\n}", []string{"z()"}},
+		{"if not true", "func f() {\n\tif !(true) {\n\t\tx()\n\t}\n}", []string{"x()"}},
+		{"if variable", "func f(c bool) {\n\tif c {\n\t\tx()\n\t}\n}", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, node := range Unreachable(buildTestCFGWithOptions(t, tt.src, Options{PruneConstants: true})) {
+				got = append(got, flatLabel(node, token.NewFileSet()))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnreachableConstantsKept(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tif false {\n\t\tx()\n\t}\n}")
+	if got := Unreachable(g); len(got) != 0 {
+		t.Errorf("got %d unreachable nodes without PruneConstants, want 0", len(got))
+	}
+	if got := len(g.Nodes[1].Edges); got != 2 {
+		t.Errorf("if has %d edges, want 2", got)
+	}
+}
+
+func TestBasicBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "straight-line prefix",
+			src: `func f(c bool) {
+	a()
+	b()
+	x := 1
+	x++
+	c = x > 2
+	if c {
+		d()
+	}
+	e()
+}`,
+			want: `0 entry
+1 block a() b() x := 1 x++ c = x > 2
+2 if c
+3 expr d()
+4 expr e()
+5 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 true
+2 -> 4 false
+3 -> 4 seq
+4 -> 5 seq
+`,
+		},
+		{
+			name: "join point",
+			src: `func f(c bool) {
+	if c {
+		a()
+	}
+	b()
+	d()
+}`,
+			want: `0 entry
+1 if c
+2 expr a()
+3 block b() d()
+4 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 3 false
+2 -> 3 seq
+3 -> 4 seq
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dumpTestCFG(t, buildTestCFG(t, tt.src).BasicBlocks()); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeIDs(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\tpanic(\"x\")\n\t}\n\tfor range 3 {\n\t\ta()\n\t}\n}")
+	for i, node := range g.Nodes {
+		if node.ID != i {
+			t.Errorf("node %d has ID %d", i, node.ID)
+		}
+	}
+	names := make(map[string]bool)
+	for _, node := range g.Nodes {
+		if names[node.Name()] {
+			t.Errorf("name %q is not unique", node.Name())
+		}
+		names[node.Name()] = true
+	}
+	if got := g.Nodes[0].Name(); got != "entry" {
+		t.Errorf("first node is %q, want entry", got)
+	}
+	if got := g.Nodes[len(g.Nodes)-1].Name(); got != "exit" {
+		t.Errorf("last node is %q, want exit", got)
+	}
+	if got := g.Nodes[1].Name(); got != "node1" {
+		t.Errorf("second node is %q, want node1", got)
+	}
+}
+
+// buildLargeCFG builds the graph of a generated function of n if statements,
+// each guarding a call, returning it with its declaration and file set.
+func buildLargeCFG(b *testing.B, n int) (*CFG, *ast.FuncDecl, *token.FileSet) {
+	b.Helper()
+	var src strings.Builder
+	src.WriteString("package p\n\nfunc f(x int) {\n")
+	for i := range n {
+		fmt.Fprintf(&src, "\tif x > %d {\n\t\ta%d()\n\t}\n", i, i)
+	}
+	src.WriteString("}\n")
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", src.String(), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	funcDecl := file.Decls[0].(*ast.FuncDecl)
+	g, err := BuildCFG(fset, funcDecl)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return g, funcDecl, fset
+}
+
+func BenchmarkNode(b *testing.B) {
+	g, funcDecl, _ := buildLargeCFG(b, 1000)
+	b.ResetTimer()
+	for range b.N {
+		for _, stmt := range funcDecl.Body.List {
+			if g.Node(stmt) == nil {
+				b.Fatal("no node for statement")
+			}
+		}
+	}
+}
+
+func BenchmarkWriteDOT(b *testing.B) {
+	g, _, fset := buildLargeCFG(b, 1000)
+	b.ResetTimer()
+	for range b.N {
+		if err := WriteDOT(io.Discard, g, fset); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNodeLabel(b *testing.B) {
+	g, _, fset := buildLargeCFG(b, 1000)
+	b.ResetTimer()
+	for range b.N {
+		for _, node := range g.Nodes {
+			NodeLabel(node, fset)
+		}
+	}
+}
+
+func TestDOTNodeStyle(t *testing.T) {
+	tests := []struct {
+		kind NodeKind
+		want string
+	}{
+		{KindEntry, `shape="diamond", style="filled", fillcolor="gray"`},
+		{KindReturn, `shape="box", style="filled", fillcolor="red"`},
+		{KindIf, `shape="box", style="filled", fillcolor="yellow"`},
+		{KindSwitch, `shape="box", style="filled", fillcolor="yellow"`},
+		{KindFor, `shape="box", style="filled", fillcolor="green"`},
+		{KindRange, `shape="box", style="filled", fillcolor="green"`},
+		{KindExpr, `shape="box"`},
+	}
+	for _, tt := range tests {
+		if got := dotNodeStyle(tt.kind); got != tt.want {
+			t.Errorf("dotNodeStyle(%s) = %s, want %s", tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestWriteDOTReturnColor(t *testing.T) {
+	g := buildTestCFG(t, "func f() int {\n\treturn 1\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	if want := `node1 [label="return 1", shape="box", style="filled", fillcolor="red"];`; !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in\n%s", want, b.String())
+	}
+}
+
+// nodeIDs returns the IDs of nodes.
+func nodeIDs(nodes []*CFGNode) []int {
+	ids := make([]int, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+	return ids
+}
+
+func TestLoops(t *testing.T) {
+	g := buildTestCFG(t, "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\tfor j := 0; j < n; j++ {\n\t\t\ta()\n\t\t}\n\t}\n}")
+	loops := Loops(g)
+	if len(loops) != 2 {
+		t.Fatalf("got %d loops, want 2", len(loops))
+	}
+	outer, inner := loops[0], loops[1]
+	if outer.Header != g.Nodes[2] || inner.Header != g.Nodes[4] {
+		t.Errorf("got headers %d and %d, want 2 and 4", outer.Header.ID, inner.Header.ID)
+	}
+	if got, want := fmt.Sprint(nodeIDs(outer.Nodes)), "[2 3 4 5 6 7]"; got != want {
+		t.Errorf("outer loop nodes: got %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(nodeIDs(inner.Nodes)), "[4 5 6]"; got != want {
+		t.Errorf("inner loop nodes: got %s, want %s", got, want)
+	}
+	if outer.Parent != nil || inner.Parent != outer {
+		t.Error("inner loop is not nested in the outer one")
+	}
+	if outer.Depth() != 1 || inner.Depth() != 2 {
+		t.Errorf("got depths %d and %d, want 1 and 2", outer.Depth(), inner.Depth())
+	}
+	if !outer.Contains(g.Nodes[5]) || inner.Contains(g.Nodes[7]) {
+		t.Error("Contains disagrees with Nodes")
+	}
+}
+
+func TestDominators(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t}\n\td()\n\treturn\n\tx()\n}")
+	idom := Dominators(g)
+	want := map[int]int{1: 0, 2: 1, 3: 1, 4: 1, 5: 4, 7: 5}
+	if len(idom) != len(want) {
+		t.Errorf("got %d dominated nodes, want %d", len(idom), len(want))
+	}
+	for node, dom := range want {
+		if got := idom[g.Nodes[node]]; got != g.Nodes[dom] {
+			t.Errorf("idom of node %d: got %v, want node %d", node, got, dom)
+		}
+	}
+	if _, ok := idom[g.Nodes[6]]; ok {
+		t.Error("unreachable node 6 has a dominator")
+	}
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want GraphStats
+	}{
+		{"empty", "func f() {}", GraphStats{Nodes: 2, Edges: 1}},
+		{
+			"loop and switch",
+			"func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\tif i > 2 {\n\t\t\ta()\n\t\t}\n\t}\n\tswitch n {\n\tcase 1:\n\t\tb()\n\t}\n}",
+			GraphStats{Nodes: 10, Edges: 12, Conditionals: 2, Loops: 1, MaxLoopDepth: 1},
+		},
+		{
+			"nested loops",
+			"func f(m [][]int) {\n\tfor _, r := range m {\n\t\tfor range r {\n\t\t}\n\t}\n}",
+			GraphStats{Nodes: 4, Edges: 5, Loops: 2, MaxLoopDepth: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Stats(buildTestCFG(t, tt.src)); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDOTDeterministic(t *testing.T) {
+	src := "func f(m map[string]int) {\n\tfor k, v := range m {\n\t\tfor range v {\n\t\t\tgo func() {\n\t\t\t\ta(k)\n\t\t\t}()\n\t\t}\n\t}\n\tswitch len(m) {\n\tcase 0:\n\tdefault:\n\t\tdefer b()\n\t}\n}"
+	writer := &DOTWriter{LoopClusters: true, Legend: true}
+	var first string
+	for i := range 10 {
+		var b strings.Builder
+		if err := writer.WriteDOT(&b, buildTestCFG(t, src), token.NewFileSet()); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = b.String()
+		} else if b.String() != first {
+			t.Fatalf("output %d differs:\n%s\nfirst:\n%s", i, b.String(), first)
+		}
+	}
+}
+
+func TestWriteDOTErrorEdges(t *testing.T) {
+	g := buildTestCFG(t, "func f(err error) error {\n\tif err != nil {\n\t\treturn err\n\t}\n\treturn nil\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"  node2 -> exit [style=\"dashed\", color=\"red\"];\n",
+		"  node3 -> exit;\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestNodeLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		node int
+		want string
+	}{
+		{"multiple results", "func f() (int, string, error) {\n\treturn 1, \"a\", nil\n}", 1, `return 1, "a", nil`},
+		{"results over two lines", "func f(a, b int, err error) (int, int, error) {\n\treturn a,\n\t\tb, err\n}", 1, "return a, b, err"},
+		{"first on one line", "func f() {\n\ta(); b()\n}", 1, "a()"},
+		{"second on one line", "func f() {\n\ta(); b()\n}", 2, "b()"},
+		{"statement over two lines", "func f() {\n\tx := g(1,\n\t\t2)\n}", 1, "x := g(1,\n\t2)"},
+		{"bare return of named results", "func f() (n int, err error) {\n\treturn\n}", 1, "return (named: n, err)"},
+		{"bare return of grouped named results", "func f() (a, b int) {\n\treturn\n}", 1, "return (named: a, b)"},
+		{"named results returned explicitly", "func f() (n int, err error) {\n\treturn 1, nil\n}", 1, "return 1, nil"},
+		{"bare return without results", "func f() {\n\treturn\n}", 1, "return"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset, funcDecl := parseTestFunc(t, tt.src)
+			g, err := BuildCFG(fset, funcDecl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := NodeLabel(g.Nodes[tt.node], fset); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		n     int
+		want  string
+		ok    bool
+	}{
+		{"abcdef", 0, "abcdef", false},
+		{"abcdef", 6, "abcdef", false},
+		{"abcdef", 4, "abc…", true},
+		{"αβγδ", 3, "αβ…", true},
+	}
+	for _, tt := range tests {
+		if got, ok := truncateLabel(tt.label, tt.n); got != tt.want || ok != tt.ok {
+			t.Errorf("truncateLabel(%q, %d) = %q, %t, want %q, %t", tt.label, tt.n, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteDOTMaxLabel(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tfmt.Println(\"a long message\")\n\ta()\n}")
+	var b strings.Builder
+	if err := (&DOTWriter{MaxLabel: 10}).WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`node1 [label="fmt.Print…", shape="box", tooltip="fmt.Println(\"a long message\")"];`,
+		`node2 [label="a()", shape="box"];`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t\treturn\n\t\tx()\n\t}\n\td()\n}")
+	tests := []struct {
+		name string
+		stop NodeKind
+		want []int
+	}{
+		{"all", "", []int{0, 1, 2, 6, 7, 3, 4}},
+		{"stop at if", KindIf, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			g.Walk(func(node *CFGNode) bool {
+				got = append(got, node.ID)
+				return node.Kind != tt.stop
+			})
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreds(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t}\n\td()\n}")
+	if got, want := fmt.Sprint(nodeIDs(g.Nodes[4].Preds)), "[2 3]"; got != want {
+		t.Errorf("join node preds: got %s, want %s", got, want)
+	}
+
+	// Preds mirror Edges in built and derived graphs alike
+	loop := buildTestCFG(t, "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\tif i > 2 {\n\t\t\tcontinue\n\t\t}\n\t\ta()\n\t}\n\treturn\n\tb()\n}")
+	for name, g := range map[string]*CFG{"built": loop, "reachable": loop.Reachable(), "blocks": loop.BasicBlocks()} {
+		want := make(map[*CFGNode][]int)
+		for _, node := range g.Nodes {
+			for _, edge := range node.Edges {
+				if edge.To != nil {
+					want[edge.To] = append(want[edge.To], node.ID)
+				}
+			}
+		}
+		for _, node := range g.Nodes {
+			if got := fmt.Sprint(nodeIDs(node.Preds)); got != fmt.Sprint(want[node]) {
+				t.Errorf("%s: node %d preds: got %s, want %v", name, node.ID, got, want[node])
+			}
+		}
+	}
+}
+
+func TestFallthroughInFinalCase(t *testing.T) {
+	var logged strings.Builder
+	g := buildTestCFGWithOptions(t, "func f(x int) {\n\tswitch x {\n\tcase 1:\n\t\ta()\n\t\tfallthrough\n\t}\n}", Options{Logger: log.New(&logged, "", 0)})
+	if want := "fallthrough in the final case of a switch\n"; logged.String() != want {
+		t.Errorf("logged %q, want %q", logged.String(), want)
+	}
+	if edges := g.Nodes[4].Edges; len(edges) != 0 {
+		t.Errorf("fallthrough has %d edges, want 0", len(edges))
+	}
+}
+
+func TestWriteDOTClosures(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tfunc() {\n\t\ta()\n\t}()\n\tg := func() {}\n\tg()\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"  subgraph cluster_node1_0 {\n  label=\"closure\";\n",
+		"  node1_0_node1 [label=\"a()\", shape=\"box\"];\n",
+		"  node1 -> node1_0_entry [label=\"func\", style=\"dashed\"];\n",
+		"  subgraph cluster_node2_0 {\n",
+		"  node2 -> node2_0_entry [label=\"func\", style=\"dashed\"];\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestWriteDOTCollapseIfs(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\tx()\n\t}\n\tif !c {\n\t\treturn\n\t}\n}")
+	want := dumpTestCFG(t, g)
+	var b strings.Builder
+	if err := (&DOTWriter{CollapseIfs: true}).WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	if strings.Contains(got, "  node2 [") {
+		t.Errorf("x() is drawn as a node in\n%s", got)
+	}
+	for _, wantLine := range []string{
+		"  node1 -> node3 [label=\"true: x()\"];\n",
+		"  node1 -> node3 [label=\"false\"];\n",
+		// A return leaves the function, so it keeps its node
+		"  node4 [label=\"return\"",
+	} {
+		if !strings.Contains(got, wantLine) {
+			t.Errorf("missing %q in\n%s", wantLine, got)
+		}
+	}
+	if dumpTestCFG(t, g) != want {
+		t.Error("collapsing changed the graph")
+	}
+}
+
+func TestWriteDOTLegend(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\ta()\n}")
+	for _, legend := range []bool{false, true} {
+		var b strings.Builder
+		if err := (&DOTWriter{Legend: legend}).WriteDOT(&b, g, token.NewFileSet()); err != nil {
+			t.Fatal(err)
+		}
+		got := b.String()
+		if strings.Contains(got, "subgraph cluster_legend {") != legend {
+			t.Errorf("legend %t: got\n%s", legend, got)
+		}
+		if strings.Contains(got, "-> legend_") || strings.Contains(got, "legend_expr ->") {
+			t.Errorf("legend is connected to the graph in\n%s", got)
+		}
+		if !legend {
+			continue
+		}
+		for _, want := range []string{
+			`  legend_panic_exit [label="exit by panic", shape="diamond", style="filled", fillcolor="red"];`,
+			`  legend_return [label="return", shape="box", style="filled", fillcolor="red"];`,
+			`  legend_defer [label="deferred call", shape="box", style="dashed"];`,
+		} {
+			if !strings.Contains(got, want+"\n") {
+				t.Errorf("missing %q in\n%s", want, got)
+			}
+		}
+	}
+}
+
+func TestWriteDOTBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, buildTestCFG(t, "func f() {\n\ta()\n}"), token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "digraph CFG {\n") || !strings.HasSuffix(got, "}\n") {
+		t.Errorf("got\n%s", got)
+	}
+}
+
+func TestDegrees(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t}\n\td()\n}")
+	tests := []struct {
+		node    int
+		in, out int
+	}{
+		{0, 0, 1},
+		{1, 1, 2},
+		{2, 1, 1},
+		{3, 1, 1},
+		{4, 2, 1},
+		{5, 1, 0},
+	}
+	for _, tt := range tests {
+		node := g.Nodes[tt.node]
+		if in, out := g.InDegree(node), g.OutDegree(node); in != tt.in || out != tt.out {
+			t.Errorf("node %d: got degrees %d, %d, want %d, %d", tt.node, in, out, tt.in, tt.out)
+		}
+	}
+}
+
+func TestRangeChannel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want NodeKind
+	}{
+		{"parameter", "func f(ch chan int) {\n\tfor v := range ch {\n\t\ta(v)\n\t}\n}", KindRangeChan},
+		{"receive-only parameter", "func f(ch <-chan int) {\n\tfor range ch {\n\t}\n}", KindRangeChan},
+		{"make", "func f() {\n\tfor v := range make(chan int) {\n\t\ta(v)\n\t}\n}", KindRangeChan},
+		{"short variable", "func f() {\n\tch := make(chan int)\n\tfor v := range ch {\n\t\ta(v)\n\t}\n}", KindRangeChan},
+		{"slice", "func f(s []int) {\n\tfor v := range s {\n\t\ta(v)\n\t}\n}", KindRange},
+		// Without type information the field's type is unknown
+		{"field", "func f(s struct{ ch chan int }) {\n\tfor v := range s.ch {\n\t\ta(v)\n\t}\n}", KindRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := buildTestCFG(t, tt.src)
+			var node *CFGNode
+			for _, n := range g.Nodes {
+				if n.Kind == KindRange || n.Kind == KindRangeChan {
+					node = n
+				}
+			}
+			if node == nil || node.Kind != tt.want {
+				t.Fatalf("got range node %v, want kind %s", node, tt.want)
+			}
+			done := "done"
+			if tt.want == KindRangeChan {
+				done = "closed"
+			}
+			if got := node.Edges[len(node.Edges)-1].Label; got != done {
+				t.Errorf("got exit label %q, want %q", got, done)
+			}
+		})
+	}
+}
+
+func TestRangeChannelTypes(t *testing.T) {
+	src := "package p\n\ntype S struct{ ch chan int }\n\nfunc f(s S) {\n\tfor v := range s.ch {\n\t\t_ = v\n\t}\n}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	if _, err := new(types.Config).Check("p", fset, []*ast.File{file}, info); err != nil {
+		t.Fatal(err)
+	}
+	g, err := BuildCFGWithOptions(fset, file.Decls[1].(*ast.FuncDecl), Options{Types: info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Nodes[1].Kind; got != KindRangeChan {
+		t.Errorf("got kind %s, want %s", got, KindRangeChan)
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "if and",
+			src:  "func f(a, b bool) {\n\tif a && b {\n\t\tx()\n\t}\n}",
+			want: `0 entry
+1 if a && b
+2 cond a
+3 cond b
+4 expr x()
+5 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 true
+2 -> 5 false
+3 -> 4 true
+3 -> 5 false
+4 -> 5 seq
+`,
+		},
+		{
+			name: "for or of and",
+			src:  "func f(a, b, c bool) {\n\tfor a || (b && c) {\n\t\tx()\n\t}\n}",
+			want: `0 entry
+1 for a || (b && c)
+2 cond a
+3 cond b
+4 cond c
+5 expr x()
+6 exit
+0 -> 1 call
+1 -> 2 seq
+2 -> 3 false
+2 -> 5 true
+3 -> 4 true
+3 -> 6 false
+4 -> 5 true
+4 -> 6 false
+5 -> 1 back
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dumpTestCFG(t, buildTestCFGWithOptions(t, tt.src, Options{ShortCircuit: true})); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+			// Without the option the condition stays whole
+			for _, node := range buildTestCFG(t, tt.src).Nodes {
+				if node.Kind == KindCond {
+					t.Errorf("got a cond node without ShortCircuit")
+				}
+			}
+		})
+	}
+}
+
+func TestTailCall(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want NodeKind
+	}{
+		{"call", "func f(n int) int {\n\treturn g(n - 1)\n}", KindTailCall},
+		{"method call", "func f(s S) error {\n\treturn s.Close()\n}", KindTailCall},
+		{"parenthesized call", "func f() int {\n\treturn (g())\n}", KindTailCall},
+		{"call and more", "func f() (int, error) {\n\treturn g(), nil\n}", KindReturn},
+		{"value", "func f(n int) int {\n\treturn n\n}", KindReturn},
+		{"bare", "func f() {\n\treturn\n}", KindReturn},
+		{"call in expression", "func f() int {\n\treturn g() + 1\n}", KindReturn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildTestCFG(t, tt.src).Nodes[1].Kind; got != tt.want {
+				t.Errorf("got kind %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDOTMeta(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\ta()\n\tb()\n}")
+	for _, node := range g.Nodes {
+		if node.Meta != nil {
+			t.Fatalf("node %d has metadata %v", node.ID, node.Meta)
+		}
+	}
+	g.Nodes[1].Meta = map[string]any{"depth": 2, "live": "x", "hidden": true}
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{nil, `node1 [label="a()", shape="box"];`},
+		{[]string{"live", "depth"}, `node1 [label="a()\nlive=x\ndepth=2", shape="box"];`},
+		{[]string{"missing"}, `node1 [label="a()", shape="box"];`},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := (&DOTWriter{Meta: tt.keys}).WriteDOT(&b, g, token.NewFileSet()); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), tt.want) {
+			t.Errorf("keys %q: missing %q in\n%s", tt.keys, tt.want, b.String())
+		}
+		if !strings.Contains(b.String(), `node2 [label="b()", shape="box"];`) {
+			t.Errorf("keys %q: node2 changed in\n%s", tt.keys, b.String())
+		}
+	}
+}
+
+// nestedIfs returns a function of n nested if statements.
+func nestedIfs(n int) string {
+	return "func f(c bool) {\n" + strings.Repeat("if c {\n", n) + "a()\n" + strings.Repeat("}\n", n) + "}"
+}
+
+func TestMaxDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		maxDepth int
+		wantErr  string
+	}{
+		{"within limit", nestedIfs(10), 20, ""},
+		{"over limit", nestedIfs(30), 20, "source.go:"},
+		{"default limit", nestedIfs(DefaultMaxDepth / 2), 0, ""},
+		{"over default limit", nestedIfs(DefaultMaxDepth + 1), 0, fmt.Sprintf("statements nested more than %d deep", DefaultMaxDepth)},
+		{"closures", "func f() {\n" + strings.Repeat("func() {\n", 15) + strings.Repeat("}()\n", 15) + "}", 10, "nested more than 10 deep"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The parser limits the nesting of scopes it resolves
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "source.go", "package p\n\n"+tt.src, parser.SkipObjectResolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := BuildCFGWithOptions(fset, file.Decls[0].(*ast.FuncDecl), Options{MaxDepth: tt.maxDepth})
+			if tt.wantErr == "" {
+				if err != nil || g == nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForShapes(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		edges []string
+		exits bool
+	}{
+		{"three clauses", "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\ta()\n\t}\n}", []string{"0 -> 1 call", "1 -> 2 seq", "2 -> 3 true", "2 -> 5 false", "4 -> 2 back"}, true},
+		{"empty clauses", "func f(c bool) {\n\tfor ; c; {\n\t\ta()\n\t}\n}", []string{"0 -> 1 call", "1 -> 2 true", "1 -> 3 false", "2 -> 1 back"}, true},
+		{"condition only", "func f(c bool) {\n\tfor c {\n\t\ta()\n\t}\n}", []string{"0 -> 1 call", "1 -> 2 true", "1 -> 3 false", "2 -> 1 back"}, true},
+		{"no clauses", "func f() {\n\tfor {\n\t\ta()\n\t}\n}", []string{"0 -> 1 call", "1 -> 2 seq", "2 -> 1 back"}, false},
+		{"no condition", "func f() {\n\tfor i := 0; ; i++ {\n\t\ta()\n\t}\n}", []string{"0 -> 1 call", "1 -> 2 seq", "2 -> 3 seq", "4 -> 2 back"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dump := dumpTestCFG(t, buildTestCFG(t, tt.src))
+			for _, edge := range tt.edges {
+				if !strings.Contains(dump, "\n"+edge+"\n") {
+					t.Errorf("missing edge %q in\n%s", edge, dump)
+				}
+			}
+			// A loop without a condition is only left by a branch
+			if exits := strings.Contains(dump, " false\n"); exits != tt.exits {
+				t.Errorf("got exit edge %t, want %t in\n%s", exits, tt.exits, dump)
+			}
+		})
+	}
+}
+
+func TestWithoutEntry(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\ta()\n\tif c {\n\t\tb()\n\t}\n}").WithoutEntry()
+	want := `0 expr a()
+1 if c
+2 expr b()
+3 exit
+0 -> 1 seq
+1 -> 2 true
+1 -> 3 false
+2 -> 3 seq
+`
+	if got := dumpTestCFG(t, g); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if preds := g.Nodes[0].Preds; len(preds) != 0 {
+		t.Errorf("root has %d predecessors", len(preds))
+	}
+}
+
+func TestReversePostOrderJoins(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t} else {\n\t\tb()\n\t}\n\td()\n\treturn\n\tx()\n}")
+	order := g.ReversePostOrder()
+	if got, want := fmt.Sprint(nodeIDs(order)), "[0 1 2 3 4 5 7]"; got != want {
+		t.Errorf("got order %s, want %s", got, want)
+	}
+	index := make(map[*CFGNode]int)
+	for i, node := range order {
+		index[node] = i
+	}
+	// Without loops, every node comes after all its reachable predecessors
+	for _, node := range order {
+		for _, pred := range node.Preds {
+			if i, ok := index[pred]; ok && i >= index[node] {
+				t.Errorf("%s comes before its predecessor %s", node.Name(), pred.Name())
+			}
+		}
+	}
+}
+
+func TestWriteDOTDeferredClosure(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tdefer a()\n\tdefer func() {\n\t\trecover()\n\t}()\n\tx()\n}")
+	var b strings.Builder
+	if err := WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"  node1 [label=\"defer a()\", shape=\"box\", style=\"dashed\"];\n",
+		"  node2 [label=\"defer func() {…}()\", shape=\"box\", style=\"dashed\"];\n",
+		"  node3 -> node2 [style=\"dashed\"];\n  node2 -> node1 [style=\"dashed\"];\n",
+		"  subgraph cluster_node2_0 {\n  label=\"deferred\";\n",
+		"  node2_0_node1 [label=\"recover()\", shape=\"box\"];\n",
+		"  node2 -> node2_0_entry [label=\"defer\", style=\"dashed\"];\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+	// A plain deferred call has no cluster
+	if strings.Contains(b.String(), "cluster_node1_") {
+		t.Errorf("defer a() has a cluster in\n%s", b.String())
+	}
+}
+
+func TestEmptyStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"loop body", "func f() {\n\tfor {\n\t\t;\n\t}\n}", "0 entry\n1 for for\n2 exit\n0 -> 1 call\n1 -> 1 back\n"},
+		{"between statements", "func f() {\n\ta();;\n\tb()\n}", "0 entry\n1 expr a()\n2 expr b()\n3 exit\n0 -> 1 call\n1 -> 2 seq\n2 -> 3 seq\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged strings.Builder
+			g := buildTestCFGWithOptions(t, tt.src, Options{Logger: log.New(&logged, "", 0)})
+			if logged.Len() > 0 {
+				t.Errorf("logged %q", logged.String())
+			}
+			if got := dumpTestCFG(t, g); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          GraphDiff
+	}{
+		{
+			name:   "moved",
+			before: "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n\tb()\n}",
+			after:  "func f(c bool) {\n\t// Comments and layout don't matter\n\n\tif c { a() }\n\n\tb()\n}",
+		},
+		{
+			name:   "added return",
+			before: "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n\tb()\n}",
+			after:  "func f(c bool) {\n\tif c {\n\t\ta()\n\t\treturn\n\t}\n\tb()\n}",
+			want: GraphDiff{
+				AddedNodes:   []string{"[return] return"},
+				AddedEdges:   []string{"[expr] a() -seq-> [return] return", "[return] return -return-> [exit]"},
+				RemovedEdges: []string{"[expr] a() -seq-> [expr] b()"},
+			},
+		},
+		{
+			name:   "removed statement",
+			before: "func f() {\n\ta()\n\tb()\n}",
+			after:  "func f() {\n\tb()\n}",
+			want: GraphDiff{
+				RemovedNodes: []string{"[expr] a()"},
+				AddedEdges:   []string{"[entry] -call-> [expr] b()"},
+				RemovedEdges: []string{"[entry] -call-> [expr] a()", "[expr] a() -seq-> [expr] b()"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			before := buildTestCFG(t, tt.before)
+			after := buildTestCFG(t, tt.after)
+			got := Diff(before, after, fset)
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got.Empty() != (fmt.Sprint(tt.want) == fmt.Sprint(GraphDiff{})) {
+				t.Errorf("Empty() = %t", got.Empty())
+			}
+		})
+	}
+}
+
+func TestStatementsSharingALine(t *testing.T) {
+	fset, funcDecl := parseTestFunc(t, "func f() {\n\tx := 1; y := 2; use(x, y)\n}")
+	g, err := BuildCFG(fset, funcDecl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"x := 1", "y := 2", "use(x, y)"}
+	seen := make(map[int]bool)
+	for i, stmt := range funcDecl.Body.List {
+		node := g.Node(stmt)
+		if got := NodeLabel(node, fset); got != want[i] {
+			t.Errorf("statement %d: got label %q, want %q", i, got, want[i])
+		}
+		if seen[node.ID] {
+			t.Errorf("statement %d: ID %d is shared", i, node.ID)
+		}
+		seen[node.ID] = true
+	}
+}
+
+// declaredKinds returns the values of the constants of type typ declared in
+// kind.go.
+func declaredKinds(t *testing.T, typ string) map[string]bool {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "kind.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]bool)
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			spec := spec.(*ast.ValueSpec)
+			if ident, ok := spec.Type.(*ast.Ident); !ok || ident.Name != typ {
+				continue
+			}
+			for _, value := range spec.Values {
+				kind := value.(*ast.BasicLit).Value
+				if kinds[kind] {
+					t.Errorf("%s %s is declared twice", typ, kind)
+				}
+				kinds[kind] = true
+			}
+		}
+	}
+	return kinds
+}
+
+func TestKinds(t *testing.T) {
+	nodeKinds, edgeKinds := declaredKinds(t, "NodeKind"), declaredKinds(t, "EdgeKind")
+	src := `func f(x int, ch chan int, m map[int]int) (err error) {
+	defer a()
+	var v int
+	v++
+	ch <- v
+	go b()
+	if x > 0 && x < 9 {
+		panic("x")
+	}
+L:
+	for i := 0; i < x; i++ {
+		for k := range m {
+			switch k {
+			case 1:
+				fallthrough
+			case 2:
+				continue L
+			}
+			break
+		}
+		goto L
+	}
+	for range ch {
+	}
+	switch any(x).(type) {
+	}
+	select {
+	case <-ch:
+	}
+	if err != nil {
+		return err
+	}
+	return g()
+}`
+	g := buildTestCFGWithOptions(t, src, Options{ShortCircuit: true})
+	for _, g := range []*CFG{g, g.BasicBlocks()} {
+		for _, node := range g.Nodes {
+			if !nodeKinds[fmt.Sprintf("%q", node.Kind)] {
+				t.Errorf("node %s has undeclared kind %q", node.Name(), node.Kind)
+			}
+			for _, edge := range node.Edges {
+				if !edgeKinds[fmt.Sprintf("%q", edge.Kind)] {
+					t.Errorf("edge from %s has undeclared kind %q", node.Name(), edge.Kind)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteDOTLoopClusters(t *testing.T) {
+	g := buildTestCFG(t, "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\tfor range n {\n\t\t\ta()\n\t\t}\n\t}\n\tb()\n}")
+	var b strings.Builder
+	if err := (&DOTWriter{LoopClusters: true}).WriteDOT(&b, g, token.NewFileSet()); err != nil {
+		t.Fatal(err)
+	}
+	want := `  subgraph cluster_loop_node2 {
+  label="";
+  style="filled";
+  fillcolor="whitesmoke";
+  node2 [label="i < n", shape="box", style="filled", fillcolor="green"];
+  node5 [label="i++", shape="box"];
+  subgraph cluster_loop_node3 {
+  label="";
+  style="filled";
+  fillcolor="whitesmoke";
+  node3 [label="for range n", shape="box", style="filled", fillcolor="green"];
+  node4 [label="a()", shape="box"];
+  }
+  }
+`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("missing\n%s\nin\n%s", want, b.String())
+	}
+	// Nodes outside loops are written once, outside the clusters
+	if n := strings.Count(b.String(), "node1 [label="); n != 1 {
+		t.Errorf("node1 is written %d times", n)
+	}
+}
+
+func TestDumpText(t *testing.T) {
+	g := buildTestCFG(t, "func f(c bool) {\n\tif c {\n\t\tx := []int{\n\t\t\t1,\n\t\t}\n\t\tuse(x)\n\t}\n}")
+	var b bytes.Buffer
+	if err := DumpText(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	want := `0 entry
+1 if c
+2 assign x := []int{1}
+3 expr use(x)
+4 exit
+0 -> 1 call
+1 -> 2 true
+1 -> 4 false
+2 -> 3 seq
+3 -> 4 seq
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestInReversePostOrderPath(t *testing.T) {
+	g := buildTestCFG(t, "func f() {\n\tgoto second\nfirst:\n\tb()\n\treturn\nsecond:\n\ta()\n\tgoto first\n}").InReversePostOrder()
+	want := `0 entry
+1 goto goto second
+2 label second:
+3 expr a()
+4 goto goto first
+5 label first:
+6 expr b()
+7 return return
+8 exit
+`
+	if got := dumpTestCFG(t, g); !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant nodes\n%s", got, want)
+	}
+	// The IDs increase along the only path through the function
+	for node := g.Nodes[0]; len(node.Edges) > 0; node = node.Edges[0].To {
+		if next := node.Edges[0].To; next.ID != node.ID+1 {
+			t.Errorf("%s leads to %s", node.Name(), next.Name())
+		}
+	}
+}
+
+func TestSplitDecls(t *testing.T) {
+	src := "func f() {\n\tvar (\n\t\ta int\n\t\tb string\n\t)\n\tconst c, d = 1, 2\n\tuse(a, b)\n}"
+	tests := []struct {
+		split bool
+		want  string
+	}{
+		{false, "0 entry\n1 decl var ( a int b string )\n2 decl const c, d = 1, 2\n3 expr use(a, b)\n4 exit\n0 -> 1 call\n1 -> 2 seq\n2 -> 3 seq\n3 -> 4 seq\n"},
+		{true, "0 entry\n1 decl var a int\n2 decl var b string\n3 decl const c, d = 1, 2\n4 expr use(a, b)\n5 exit\n0 -> 1 call\n1 -> 2 seq\n2 -> 3 seq\n3 -> 4 seq\n4 -> 5 seq\n"},
+	}
+	for _, tt := range tests {
+		if got := dumpTestCFG(t, buildTestCFGWithOptions(t, src, Options{SplitDecls: tt.split})); got != tt.want {
+			t.Errorf("split %t: got\n%s\nwant\n%s", tt.split, got, tt.want)
+		}
+	}
+
+	// A grouped declaration keeps its layout
+	fset, funcDecl := parseTestFunc(t, src)
+	g, err := BuildCFG(fset, funcDecl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := NodeLabel(g.Nodes[1], fset), "var (\n\ta\tint\n\tb\tstring\n)"; got != want {
+		t.Errorf("got label %q, want %q", got, want)
+	}
+}
+
+func TestEdgeTo(t *testing.T) {
+	src := "func f(x int, m []int) {\n\tdefer a()\n\tfor _, v := range m {\n\t\tswitch v {\n\t\tcase 1:\n\t\t\tfallthrough\n\t\tcase 2:\n\t\t\tcontinue\n\t\t}\n\t\tif v > x {\n\t\t\tpanic(v)\n\t\t}\n\t}\n\tgo func() {\n\t\treturn\n\t}()\n}"
+	var check func(name string, g *CFG)
+	check = func(name string, g *CFG) {
+		for _, node := range g.Nodes {
+			for i, edge := range node.Edges {
+				if edge.To == nil {
+					t.Errorf("%s: edge %d of %s has no To", name, i, node.Name())
+					continue
+				}
+				if g.Nodes[edge.To.ID] != edge.To {
+					t.Errorf("%s: edge %d of %s leads out of the graph", name, i, node.Name())
+				}
+				if edge.Stmt != nil && g.Node(edge.Stmt) != edge.To {
+					t.Errorf("%s: edge %d of %s leads to %s, not the node of its Stmt", name, i, node.Name(), edge.To.Name())
+				}
+			}
+			for _, closure := range node.Closures {
+				check(name+" closure", closure)
+			}
+		}
+	}
+	check("built", buildTestCFG(t, src))
+	check("short circuit", buildTestCFGWithOptions(t, src, Options{ShortCircuit: true}))
+}
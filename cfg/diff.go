@@ -0,0 +1,75 @@
+package cfg
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// GraphDiff lists the nodes and edges one graph has that another lacks, each
+// described by the kind and label of its nodes.
+type GraphDiff struct {
+	AddedNodes   []string
+	RemovedNodes []string
+	AddedEdges   []string
+	RemovedEdges []string
+}
+
+// Empty reports whether the graphs compared have the same structure.
+func (d GraphDiff) Empty() bool {
+	return len(d.AddedNodes) == 0 && len(d.RemovedNodes) == 0 && len(d.AddedEdges) == 0 && len(d.RemovedEdges) == 0
+}
+
+// Diff compares the graphs of two versions of a function. Nodes are matched by
+// kind and label rather than position, so moving code about without changing
+// its control flow gives an empty diff. Repeated nodes and edges are counted,
+// so a statement duplicated in after shows up as added.
+func Diff(before, after *CFG, fset *token.FileSet) GraphDiff {
+	beforeNodes, beforeEdges := describe(before, fset)
+	afterNodes, afterEdges := describe(after, fset)
+	var d GraphDiff
+	d.AddedNodes, d.RemovedNodes = subtract(afterNodes, beforeNodes), subtract(beforeNodes, afterNodes)
+	d.AddedEdges, d.RemovedEdges = subtract(afterEdges, beforeEdges), subtract(beforeEdges, afterEdges)
+	return d
+}
+
+// describe returns a description of each node and each edge of g, in order.
+func describe(g *CFG, fset *token.FileSet) (nodes, edges []string) {
+	key := func(node *CFGNode) string {
+		return strings.TrimSpace(fmt.Sprintf("[%s] %s", node.Kind, flatLabel(node, fset)))
+	}
+	for _, node := range g.Nodes {
+		nodes = append(nodes, key(node))
+	}
+	for _, node := range g.Nodes {
+		for _, edge := range node.Edges {
+			succ := edge.To
+			if succ == nil {
+				continue
+			}
+			kind := string(edge.Kind)
+			if edge.Label != "" && edge.Label != kind {
+				kind += " " + edge.Label
+			}
+			edges = append(edges, fmt.Sprintf("%s -%s-> %s", key(node), kind, key(succ)))
+		}
+	}
+	return nodes, edges
+}
+
+// subtract returns the elements of a not matched by one in b, in order.
+func subtract(a, b []string) []string {
+	counts := make(map[string]int)
+	for _, s := range b {
+		counts[s]++
+	}
+	var rest []string
+	for _, s := range a {
+		if counts[s] > 0 {
+			counts[s]--
+			continue
+		}
+		rest = append(rest, s)
+	}
+	return rest
+}
@@ -0,0 +1,88 @@
+package cfg
+
+// Dominators maps each node reachable from the entry, other than the entry
+// itself, to its immediate dominator: the closest node that every path from the
+// entry to it passes through. Unreachable nodes are left out. It uses the
+// iterative algorithm of Cooper, Harvey and Kennedy, "A Simple, Fast Dominance
+// Algorithm".
+func Dominators(cfg *CFG) map[*CFGNode]*CFGNode {
+	order := cfg.ReversePostOrder()
+	index := make(map[*CFGNode]int, len(order))
+	for i, node := range order {
+		index[node] = i
+	}
+
+	entry := cfg.Nodes[0]
+	idom := map[*CFGNode]*CFGNode{entry: entry}
+	intersect := func(a, b *CFGNode) *CFGNode {
+		for a != b {
+			for index[a] > index[b] {
+				a = idom[a]
+			}
+			for index[b] > index[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, node := range order[1:] {
+			var dom *CFGNode
+			for _, pred := range node.Preds {
+				if idom[pred] == nil {
+					continue
+				}
+				if dom == nil {
+					dom = pred
+				} else {
+					dom = intersect(pred, dom)
+				}
+			}
+			if idom[node] != dom {
+				idom[node] = dom
+				changed = true
+			}
+		}
+	}
+	delete(idom, entry)
+	return idom
+}
+
+// dominates reports whether a dominates b, given the immediate dominators.
+func dominates(idom map[*CFGNode]*CFGNode, a, b *CFGNode) bool {
+	for ; b != nil; b = idom[b] {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// ReversePostOrder returns the nodes reachable from the entry in reverse
+// postorder of a depth-first walk, visiting each once, so that each node comes
+// before its successors other than along back edges. It is the order forward
+// dataflow analyses converge fastest in, and reversed, backward ones. Edges are
+// walked last to first, so that the first successor of a branch, such as the
+// true branch of an if, comes first in the order.
+func (c *CFG) ReversePostOrder() []*CFGNode {
+	visited := make(map[*CFGNode]bool)
+	var post []*CFGNode
+	var visit func(node *CFGNode)
+	visit = func(node *CFGNode) {
+		visited[node] = true
+		for i := len(node.Edges) - 1; i >= 0; i-- {
+			if succ := node.Edges[i].To; succ != nil && !visited[succ] {
+				visit(succ)
+			}
+		}
+		post = append(post, node)
+	}
+	visit(c.Nodes[0])
+
+	order := make([]*CFGNode, len(post))
+	for i, node := range post {
+		order[len(post)-1-i] = node
+	}
+	return order
+}
@@ -0,0 +1,326 @@
+package cfg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"strings"
+)
+
+// WriteDOT writes g to w as a Graphviz DOT graph, labelling nodes with
+// NodeLabel.
+func WriteDOT(w io.Writer, g *CFG, fset *token.FileSet) error {
+	return (&DOTWriter{}).WriteDOT(w, g, fset)
+}
+
+// DOTWriter writes graphs in the Graphviz DOT language. The zero value draws
+// every node with its full NodeLabel.
+type DOTWriter struct {
+	// Label returns the label of a node, or NodeLabel if nil.
+	Label func(node *CFGNode, fset *token.FileSet) string
+	// MaxLabel is the number of characters node labels are truncated to,
+	// keeping the full text as a tooltip, or 0 for no limit.
+	MaxLabel int
+	// CollapseIfs draws single-statement if bodies as edge labels rather than
+	// nodes.
+	CollapseIfs bool
+	// Legend adds a cluster showing how each kind of node is drawn.
+	Legend bool
+	// LoopClusters draws the nodes of each loop in a shaded cluster, nested
+	// as the loops are.
+	LoopClusters bool
+	// Meta lists the keys of CFGNode.Meta to show, in order, as "key=value"
+	// lines under the label of each node that has them.
+	Meta []string
+}
+
+// DOTCluster is a graph drawn as a cluster of its own, titled Title. ID
+// prefixes its node IDs and must be unique among the clusters written together.
+type DOTCluster struct {
+	ID    string
+	Title string
+	Graph *CFG
+}
+
+// WriteDOT writes g to w as a DOT graph.
+func (d *DOTWriter) WriteDOT(w io.Writer, g *CFG, fset *token.FileSet) error {
+	fmt.Fprintln(w, "digraph CFG {")
+	d.writeGraph(w, g, fset, "")
+	if d.Legend {
+		writeLegend(w)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// WriteClusters writes clusters to w as a single DOT graph.
+func (d *DOTWriter) WriteClusters(w io.Writer, clusters []DOTCluster, fset *token.FileSet) error {
+	fmt.Fprintln(w, "digraph CFG {")
+	for _, cluster := range clusters {
+		fmt.Fprintf(w, "  subgraph cluster_%s {\n", cluster.ID)
+		fmt.Fprintf(w, "  label=\"%s\";\n", escapeDOTLabel(cluster.Title))
+		// Node IDs are prefixed with the cluster ID to keep clusters apart
+		d.writeGraph(w, cluster.Graph, fset, cluster.ID+"_")
+		fmt.Fprintln(w, "  }")
+	}
+	if d.Legend {
+		writeLegend(w)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func (d *DOTWriter) label(node *CFGNode, fset *token.FileSet) string {
+	if d.Label != nil {
+		return d.Label(node, fset)
+	}
+	return NodeLabel(node, fset)
+}
+
+// writeGraph writes the nodes and edges of g as DOT statements, with the
+// bodies of function literals drawn as nested clusters. Node IDs are prefixed
+// with prefix so that nested graphs don't clash with the enclosing one.
+func (d *DOTWriter) writeGraph(w io.Writer, g *CFG, fset *token.FileSet, prefix string) {
+	fmt.Fprintf(w, "  // complexity: %d\n", Complexity(g))
+	collapsed := make(map[*CFGNode]bool)
+	if d.CollapseIfs {
+		collapsed = collapsedBodies(g)
+	}
+	writeNode := func(node *CFGNode) {
+		if collapsed[node] {
+			return
+		}
+		attrs := dotNodeStyle(node.Kind)
+		label := d.label(node, fset)
+		for _, key := range d.Meta {
+			if value, ok := node.Meta[key]; ok {
+				label = fmt.Sprintf("%s\n%s=%v", strings.TrimRight(label, "\n"), key, value)
+			}
+		}
+		if short, ok := truncateLabel(label, d.MaxLabel); ok {
+			attrs += fmt.Sprintf(", tooltip=\"%s\"", escapeDOTLabel(label))
+			label = short
+		}
+		fmt.Fprintf(w, "  %s [label=\"%s\", %s];\n", prefix+node.Name(), escapeDOTLabel(label), attrs)
+	}
+	if d.LoopClusters {
+		writeLoopClusters(w, g, prefix, writeNode)
+	} else {
+		for _, node := range g.Nodes {
+			writeNode(node)
+		}
+	}
+	for _, node := range g.Nodes {
+		if collapsed[node] {
+			continue
+		}
+		for _, edge := range node.Edges {
+			// An edge into a collapsed body skips over it, carrying its label
+			if body := edge.To; collapsed[body] {
+				edge = &CFGEdge{Stmt: body.Edges[0].Stmt, To: body.Edges[0].To, Kind: edge.Kind, Label: edge.Label + ": " + strings.TrimSpace(d.label(body, fset))}
+			}
+			attrs := ""
+			if edge.Label != "" {
+				attrs = fmt.Sprintf("label=\"%s\"", escapeDOTLabel(edge.Label))
+			}
+			// Error returns stand out from successful ones
+			if edge.Kind == EdgeError {
+				attrs = strings.TrimPrefix(attrs+", style=\"dashed\", color=\"red\"", ", ")
+			}
+			to := ""
+			if edge.To != nil {
+				to = edge.To.Name()
+			}
+			if attrs != "" {
+				fmt.Fprintf(w, "  %s -> %s [%s];\n", prefix+node.Name(), prefix+to, attrs)
+				continue
+			}
+			fmt.Fprintf(w, "  %s -> %s;\n", prefix+node.Name(), prefix+to)
+		}
+	}
+	// Deferred calls run in LIFO order whenever the function returns or panics
+	for _, edge := range g.DeferredEdges() {
+		fmt.Fprintf(w, "  %s -> %s [style=\"dashed\"];\n", prefix+edge.From.Name(), prefix+edge.To.Name())
+	}
+	// Function literal bodies hang off their node in a cluster of their own
+	for _, node := range g.Nodes {
+		for i, closure := range node.Closures {
+			id := fmt.Sprintf("%s%s_%d", prefix, node.Name(), i)
+			label, edgeLabel := ClosureLabels(node, i)
+			fmt.Fprintf(w, "  subgraph cluster_%s {\n", id)
+			fmt.Fprintf(w, "  label=\"%s\";\n", label)
+			d.writeGraph(w, closure, fset, id+"_")
+			fmt.Fprintln(w, "  }")
+			fmt.Fprintf(w, "  %s -> %s [label=\"%s\", style=\"dashed\"];\n", prefix+node.Name(), id+"_"+closure.Nodes[0].Name(), edgeLabel)
+		}
+	}
+}
+
+// writeLoopClusters writes the nodes of g with writeNode, those of each loop
+// inside a shaded cluster nested in that of the enclosing loop.
+func writeLoopClusters(w io.Writer, g *CFG, prefix string, writeNode func(*CFGNode)) {
+	loops := Loops(g)
+	// Each node is written in the cluster of the innermost loop holding it
+	innermost := make(map[*CFGNode]*Loop)
+	inner := make(map[*Loop][]*Loop)
+	for _, loop := range loops {
+		for _, node := range loop.Nodes {
+			if innermost[node] == nil || loop.Depth() > innermost[node].Depth() {
+				innermost[node] = loop
+			}
+		}
+		if loop.Parent != nil {
+			inner[loop.Parent] = append(inner[loop.Parent], loop)
+		}
+	}
+	var writeLoop func(loop *Loop)
+	writeLoop = func(loop *Loop) {
+		fmt.Fprintf(w, "  subgraph cluster_%sloop_%s {\n", prefix, loop.Header.Name())
+		fmt.Fprintln(w, "  label=\"\";")
+		fmt.Fprintln(w, "  style=\"filled\";")
+		fmt.Fprintln(w, "  fillcolor=\"whitesmoke\";")
+		for _, node := range loop.Nodes {
+			if innermost[node] == loop {
+				writeNode(node)
+			}
+		}
+		for _, child := range inner[loop] {
+			writeLoop(child)
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, node := range g.Nodes {
+		if innermost[node] == nil {
+			writeNode(node)
+		}
+	}
+	for _, loop := range loops {
+		if loop.Parent == nil {
+			writeLoop(loop)
+		}
+	}
+}
+
+// collapsedBodies returns the nodes of g that are the whole body of an if
+// statement and simple enough to be drawn as a label on the if's true edge:
+// plain statements entered only from the if and carrying on to one place.
+func collapsedBodies(g *CFG) map[*CFGNode]bool {
+	collapsed := make(map[*CFGNode]bool)
+	for _, node := range g.Nodes {
+		stmt, ok := node.Stmt.(*ast.IfStmt)
+		if !ok || node.Kind != KindIf || len(stmt.Body.List) != 1 {
+			continue
+		}
+		body := g.Node(stmt.Body.List[0])
+		if body == nil || len(body.Preds) != 1 || len(body.Edges) != 1 || len(body.Closures) > 0 {
+			continue
+		}
+		switch body.Kind {
+		case KindExpr, KindAssign, KindDecl, KindSend, KindIncDec:
+			collapsed[body] = true
+		}
+	}
+	return collapsed
+}
+
+// ClosureLabels returns the cluster and edge labels for the i'th closure of
+// node: "goroutine" and "go" for the function a go statement spawns,
+// "deferred" and "defer" for the function a defer statement registers, and
+// "closure" and "func" for any other function literal.
+func ClosureLabels(node *CFGNode, i int) (label, edgeLabel string) {
+	switch stmt := node.Stmt.(type) {
+	case *ast.GoStmt:
+		if _, ok := stmt.Call.Fun.(*ast.FuncLit); ok && i == 0 {
+			return "goroutine", "go"
+		}
+	case *ast.DeferStmt:
+		if _, ok := stmt.Call.Fun.(*ast.FuncLit); ok && i == 0 {
+			return "deferred", "defer"
+		}
+	}
+	return "closure", "func"
+}
+
+// dotNodeStyle returns the DOT attributes a node of the given kind is drawn
+// with, its shape first.
+func dotNodeStyle(kind NodeKind) string {
+	// Assign shapes based on node kind
+	shape := "box" // default shape
+	if kind == KindEntry || kind == KindExit || kind == KindPanicExit {
+		shape = "diamond"
+	}
+	attrs := fmt.Sprintf("shape=\"%s\"", shape)
+	if kind == KindDefer {
+		return attrs + ", style=\"dashed\""
+	}
+	if color, ok := nodeColors[kind]; ok {
+		attrs += fmt.Sprintf(", style=\"filled\", fillcolor=\"%s\"", color)
+	}
+	return attrs
+}
+
+// legendEntries lists the node kinds the DOT legend explains, with the
+// meaning of each.
+var legendEntries = []struct {
+	kind        NodeKind
+	description string
+}{
+	{KindEntry, "entry / exit"},
+	{KindPanicExit, "exit by panic"},
+	{KindIf, "if / switch / select"},
+	{KindFor, "for / range loop"},
+	{KindReturn, "return"},
+	{KindTailCall, "return of a call"},
+	{KindPanic, "panic call"},
+	{KindDefer, "deferred call"},
+	{KindExpr, "other statement"},
+}
+
+// writeLegend writes a cluster showing how each kind of node is drawn. Its
+// nodes are not connected to any graph.
+func writeLegend(w io.Writer) {
+	fmt.Fprintln(w, "  subgraph cluster_legend {")
+	fmt.Fprintln(w, "  label=\"legend\";")
+	for _, entry := range legendEntries {
+		fmt.Fprintf(w, "  legend_%s [label=\"%s\", %s];\n", strings.ReplaceAll(string(entry.kind), "-", "_"), entry.description, dotNodeStyle(entry.kind))
+	}
+	fmt.Fprintln(w, "  }")
+}
+
+// nodeColors maps node kinds to the DOT fill color they are drawn with.
+var nodeColors = map[NodeKind]string{
+	KindEntry:      "gray",
+	KindExit:       "gray",
+	KindPanicExit:  "red",
+	KindPanic:      "red",
+	KindReturn:     "red",
+	KindTailCall:   "orange",
+	KindIf:         "yellow",
+	KindCond:       "yellow",
+	KindSwitch:     "yellow",
+	KindTypeSwitch: "yellow",
+	KindSelect:     "yellow",
+	KindFor:        "green",
+	KindRange:      "green",
+	KindRangeChan:  "green",
+}
+
+// truncateLabel shortens label to n characters, the last of them an ellipsis,
+// reporting whether it had to. An n of 0 or less means no limit.
+func truncateLabel(label string, n int) (string, bool) {
+	runes := []rune(label)
+	if n <= 0 || len(runes) <= n {
+		return label, false
+	}
+	return string(runes[:n-1]) + "…", true
+}
+
+// dotLabelEscaper escapes the characters that would end or corrupt a quoted
+// DOT string.
+var dotLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeDOTLabel escapes s for use inside a double-quoted DOT attribute.
+func escapeDOTLabel(s string) string {
+	return dotLabelEscaper.Replace(s)
+}
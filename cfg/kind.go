@@ -0,0 +1,83 @@
+package cfg
+
+// NodeKind is what a node stands for, usually the kind of its statement.
+type NodeKind string
+
+// The kinds of node a graph is built from.
+const (
+	// KindEntry and KindExit are the synthetic nodes a graph starts and ends
+	// with, and KindPanicExit the one panics lead to.
+	KindEntry     NodeKind = "entry"
+	KindExit      NodeKind = "exit"
+	KindPanicExit NodeKind = "panic-exit"
+
+	KindExpr   NodeKind = "expr"
+	KindAssign NodeKind = "assign"
+	KindDecl   NodeKind = "decl"
+	KindSend   NodeKind = "send"
+	KindIncDec NodeKind = "incdec"
+	KindGo     NodeKind = "go"
+	KindDefer  NodeKind = "defer"
+	// KindPanic is a call to the panic builtin.
+	KindPanic  NodeKind = "panic"
+	KindReturn NodeKind = "return"
+	// KindTailCall is a return of the results of a single call.
+	KindTailCall NodeKind = "tailcall"
+
+	KindIf NodeKind = "if"
+	// KindCond is an operand of an && or || condition, with
+	// Options.ShortCircuit.
+	KindCond NodeKind = "cond"
+	// KindInit is the init statement of an if, for or switch statement.
+	KindInit NodeKind = "init"
+	KindFor  NodeKind = "for"
+	// KindRange ranges over anything but a channel, which KindRangeChan does.
+	KindRange      NodeKind = "range"
+	KindRangeChan  NodeKind = "range-chan"
+	KindSwitch     NodeKind = "switch"
+	KindTypeSwitch NodeKind = "typeswitch"
+	KindCase       NodeKind = "case"
+	KindSelect     NodeKind = "select"
+	KindComm       NodeKind = "comm"
+
+	KindLabel       NodeKind = "label"
+	KindGoto        NodeKind = "goto"
+	KindBreak       NodeKind = "break"
+	KindContinue    NodeKind = "continue"
+	KindFallthrough NodeKind = "fallthrough"
+
+	// KindBlock is a basic block coalescing the nodes in its Block.
+	KindBlock NodeKind = "block"
+)
+
+// EdgeKind is what an edge stands for.
+type EdgeKind string
+
+// The kinds of edge between nodes.
+const (
+	// EdgeCall leaves the entry node.
+	EdgeCall EdgeKind = "call"
+	// EdgeSeq falls through to the next statement.
+	EdgeSeq EdgeKind = "seq"
+	// EdgeTrue and EdgeFalse are the branches of a condition.
+	EdgeTrue  EdgeKind = "true"
+	EdgeFalse EdgeKind = "false"
+	// EdgeCase and EdgeComm lead into switch and select clauses.
+	EdgeCase EdgeKind = "case"
+	EdgeComm EdgeKind = "comm"
+	// EdgeDone leaves a finished range loop, labelled "closed" for a channel.
+	EdgeDone EdgeKind = "done"
+	// EdgeBack goes back to a loop header.
+	EdgeBack EdgeKind = "back"
+
+	EdgeBreak       EdgeKind = "break"
+	EdgeContinue    EdgeKind = "continue"
+	EdgeGoto        EdgeKind = "goto"
+	EdgeFallthrough EdgeKind = "fallthrough"
+
+	// EdgeReturn, EdgeError and EdgePanic leave the function, EdgeError by
+	// returning a non-nil error.
+	EdgeReturn EdgeKind = "return"
+	EdgeError  EdgeKind = "error"
+	EdgePanic  EdgeKind = "panic"
+)
@@ -0,0 +1,138 @@
+package cfg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"strings"
+)
+
+// NodeLabel renders the statement of node from its AST. Compound statements
+// show just their header, since their bodies get nodes of their own. A call
+// to a method is followed by the method's full name on a line of its own.
+func NodeLabel(node *CFGNode, fset *token.FileSet) string {
+	if node.Method != nil {
+		return stmtLabel(node, fset) + "\n" + node.Method.FullName()
+	}
+	return stmtLabel(node, fset)
+}
+
+// stmtLabel is NodeLabel without the method name.
+func stmtLabel(node *CFGNode, fset *token.FileSet) string {
+	if node.Kind == KindEntry || node.Kind == KindExit {
+		return ""
+	}
+	if node.Kind == KindPanicExit {
+		return "panic"
+	}
+	if len(node.Block) > 0 {
+		return BlockLabel(node, func(member *CFGNode) string {
+			return NodeLabel(member, fset)
+		})
+	}
+	switch stmt := node.Stmt.(type) {
+	case *ast.LabeledStmt:
+		return stmt.Label.Name + ":"
+	case *ast.ReturnStmt:
+		// A bare return returns the named results as they stand
+		if len(stmt.Results) == 0 && len(node.NamedResults) > 0 {
+			names := make([]string, len(node.NamedResults))
+			for i, name := range node.NamedResults {
+				names[i] = name.Name
+			}
+			return "return (named: " + strings.Join(names, ", ") + ")"
+		}
+		// Render the results from the AST, as they may span several lines
+		results := make([]string, len(stmt.Results))
+		for i, result := range stmt.Results {
+			results[i] = NodeText(result, fset)
+		}
+		return strings.TrimSpace("return " + strings.Join(results, ", "))
+	case *ast.IfStmt:
+		// Conditionals show just what they test, the init having a node of
+		// its own
+		return NodeText(stmt.Cond, fset)
+	case *ast.ForStmt:
+		if stmt.Cond == nil {
+			return "for"
+		}
+		return NodeText(stmt.Cond, fset)
+	case *ast.RangeStmt:
+		label := "for"
+		if stmt.Key != nil {
+			label += " " + NodeText(stmt.Key, fset)
+			if stmt.Value != nil {
+				label += ", " + NodeText(stmt.Value, fset)
+			}
+			label += " " + stmt.Tok.String()
+		}
+		return label + " range " + NodeText(stmt.X, fset)
+	case *ast.SwitchStmt:
+		if stmt.Tag == nil {
+			return "switch"
+		}
+		return NodeText(stmt.Tag, fset)
+	case *ast.TypeSwitchStmt:
+		// Show the binding so the switched variable is visible
+		return NodeText(stmt.Assign, fset)
+	case *ast.SelectStmt:
+		return "select"
+	case *ast.CaseClause:
+		if stmt.List == nil {
+			return "default:"
+		}
+		exprs := make([]string, len(stmt.List))
+		for i, expr := range stmt.List {
+			exprs[i] = NodeText(expr, fset)
+		}
+		return "case " + strings.Join(exprs, ", ") + ":"
+	case *ast.CommClause:
+		if stmt.Comm == nil {
+			return "default:"
+		}
+		return "case " + NodeText(stmt.Comm, fset) + ":"
+	case *ast.GoStmt:
+		return "go " + callText(stmt.Call, fset)
+	case *ast.DeferStmt:
+		return "defer " + callText(stmt.Call, fset)
+	}
+	return NodeText(node.Stmt, fset)
+}
+
+// BlockLabel returns the label of a basic-block node, listing the labels label
+// gives the statements it coalesces, one per line.
+func BlockLabel(node *CFGNode, label func(member *CFGNode) string) string {
+	lines := make([]string, len(node.Block))
+	for i, member := range node.Block {
+		lines[i] = strings.TrimSpace(label(member))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// flatLabel returns the NodeLabel of node with its layout collapsed onto a
+// single line.
+func flatLabel(node *CFGNode, fset *token.FileSet) string {
+	return strings.Join(strings.Fields(NodeLabel(node, fset)), " ")
+}
+
+// callText renders a call, eliding the body of a function literal being
+// called, since that is drawn as a graph of its own.
+func callText(call *ast.CallExpr, fset *token.FileSet) string {
+	lit, ok := call.Fun.(*ast.FuncLit)
+	if !ok {
+		return NodeText(call, fset)
+	}
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = NodeText(arg, fset)
+	}
+	return NodeText(lit.Type, fset) + " {…}(" + strings.Join(args, ", ") + ")"
+}
+
+// NodeText renders an AST node back to Go source.
+func NodeText(n ast.Node, fset *token.FileSet) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, n)
+	return buf.String()
+}
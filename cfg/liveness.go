@@ -0,0 +1,192 @@
+package cfg
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Liveness returns the variables live on entry to each node: those read on
+// some path from the node before being assigned. Variables are told apart by
+// name, and only those the parser resolved to a declaration in the file are
+// counted. A variable a closure refers to counts as read where the closure is.
+func Liveness(cfg *CFG) map[*CFGNode]map[string]bool {
+	uses := make(map[*CFGNode]map[string]bool, len(cfg.Nodes))
+	defs := make(map[*CFGNode]map[string]bool, len(cfg.Nodes))
+	live := make(map[*CFGNode]map[string]bool, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		uses[node], defs[node] = nodeUseDef(node)
+		live[node] = make(map[string]bool)
+	}
+
+	// Iterate to a fixed point, visiting nodes in reverse order as liveness
+	// flows backwards
+	for changed := true; changed; {
+		changed = false
+		for i := len(cfg.Nodes) - 1; i >= 0; i-- {
+			node := cfg.Nodes[i]
+			in := live[node]
+			for name := range uses[node] {
+				if !in[name] {
+					in[name] = true
+					changed = true
+				}
+			}
+			for _, edge := range node.Edges {
+				succ := edge.To
+				if succ == nil {
+					continue
+				}
+				for name := range live[succ] {
+					if !defs[node][name] && !in[name] {
+						in[name] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return live
+}
+
+// nodeUseDef returns the variables node reads before assigning them, and those
+// it assigns.
+func nodeUseDef(node *CFGNode) (uses, defs map[string]bool) {
+	uses, defs = make(map[string]bool), make(map[string]bool)
+	// lit is the function literal being inspected, whose own variables
+	// aren't the function's
+	var lit *ast.FuncLit
+	var use func(n ast.Node)
+	use = func(n ast.Node) {
+		if n == nil {
+			return
+		}
+		ast.Inspect(n, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.FuncLit:
+				if lit == nil {
+					lit = x
+					use(x.Body)
+					lit = nil
+					return false
+				}
+			case *ast.SelectorExpr:
+				// The selected field or method isn't a variable
+				use(x.X)
+				return false
+			case *ast.Ident:
+				if isVar(x) && !defs[x.Name] && !declaredIn(x, lit) {
+					uses[x.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	def := func(n ast.Expr) {
+		// Assigning through an index or field reads the variable rather than
+		// replacing it
+		if ident, ok := n.(*ast.Ident); ok {
+			if isVar(ident) {
+				defs[ident.Name] = true
+			}
+			return
+		}
+		use(n)
+	}
+
+	var stmtUseDef func(stmt ast.Stmt)
+	stmtUseDef = func(stmt ast.Stmt) {
+		switch stmt := stmt.(type) {
+		case nil:
+		case *ast.AssignStmt:
+			for _, rhs := range stmt.Rhs {
+				use(rhs)
+			}
+			if stmt.Tok != token.ASSIGN && stmt.Tok != token.DEFINE {
+				// An operator assignment reads its operand too
+				for _, lhs := range stmt.Lhs {
+					use(lhs)
+				}
+			}
+			for _, lhs := range stmt.Lhs {
+				def(lhs)
+			}
+		case *ast.IncDecStmt:
+			use(stmt.X)
+			def(stmt.X)
+		case *ast.DeclStmt:
+			gen, ok := stmt.Decl.(*ast.GenDecl)
+			if !ok {
+				return
+			}
+			for _, spec := range gen.Specs {
+				if spec, ok := spec.(*ast.ValueSpec); ok {
+					for _, value := range spec.Values {
+						use(value)
+					}
+					for _, name := range spec.Names {
+						def(name)
+					}
+				}
+			}
+		case *ast.IfStmt:
+			// The init statement has a node of its own, as do those of for
+			// and switch statements
+			use(stmt.Cond)
+		case *ast.ForStmt:
+			// The post statement has a node of its own too
+			use(stmt.Cond)
+		case *ast.RangeStmt:
+			use(stmt.X)
+			if stmt.Tok != token.ILLEGAL {
+				if stmt.Key != nil {
+					def(stmt.Key)
+				}
+				if stmt.Value != nil {
+					def(stmt.Value)
+				}
+			}
+		case *ast.SwitchStmt:
+			use(stmt.Tag)
+		case *ast.TypeSwitchStmt:
+			stmtUseDef(stmt.Assign)
+		case *ast.CaseClause:
+			for _, expr := range stmt.List {
+				use(expr)
+			}
+		case *ast.CommClause:
+			stmtUseDef(stmt.Comm)
+		case *ast.LabeledStmt, *ast.BranchStmt, *ast.SelectStmt, *ast.BlockStmt:
+			// Their statements have nodes of their own
+		default:
+			use(stmt)
+		}
+	}
+
+	members := node.Block
+	if len(members) == 0 {
+		members = []*CFGNode{node}
+	}
+	for _, member := range members {
+		switch member.Kind {
+		case KindEntry, KindExit, KindPanicExit:
+			continue
+		}
+		stmtUseDef(member.Stmt)
+		// A bare return reads the named results
+		for _, name := range member.NamedResults {
+			use(name)
+		}
+	}
+	return uses, defs
+}
+
+// isVar reports whether ident refers to a variable declared in the file.
+func isVar(ident *ast.Ident) bool {
+	return ident.Name != "_" && ident.Obj != nil && ident.Obj.Kind == ast.Var
+}
+
+// declaredIn reports whether ident is declared within lit, if lit isn't nil.
+func declaredIn(ident *ast.Ident, lit *ast.FuncLit) bool {
+	decl, ok := ident.Obj.Decl.(ast.Node)
+	return lit != nil && ok && decl.Pos() >= lit.Pos() && decl.Pos() < lit.End()
+}
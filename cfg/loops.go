@@ -0,0 +1,93 @@
+package cfg
+
+import "sort"
+
+// Loop is a natural loop: a cycle that can only be entered through its header.
+type Loop struct {
+	Header *CFGNode
+	// Nodes holds the nodes of the loop, header included, in graph order.
+	Nodes []*CFGNode
+	// Parent is the innermost loop enclosing this one, or nil.
+	Parent *Loop
+}
+
+// Depth returns the nesting depth of the loop, 1 for an outermost loop.
+func (l *Loop) Depth() int {
+	depth := 0
+	for ; l != nil; l = l.Parent {
+		depth++
+	}
+	return depth
+}
+
+// Contains reports whether node belongs to the loop.
+func (l *Loop) Contains(node *CFGNode) bool {
+	for _, n := range l.Nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Loops returns the natural loops of the graph, ordered by header. A loop is
+// found for each back edge, an edge to a node that dominates its source, and
+// holds the nodes that reach the source without passing through the header.
+// Back edges sharing a header make up a single loop.
+func Loops(cfg *CFG) []*Loop {
+	idom := Dominators(cfg)
+
+	members := make(map[*CFGNode]map[*CFGNode]bool)
+	for _, node := range cfg.Nodes {
+		if idom[node] == nil && node != cfg.Nodes[0] {
+			continue // unreachable
+		}
+		for _, edge := range node.Edges {
+			header := edge.To
+			if header == nil || !dominates(idom, header, node) {
+				continue
+			}
+			// Walk backwards from the source of the back edge up to the header
+			body := members[header]
+			if body == nil {
+				body = map[*CFGNode]bool{header: true}
+				members[header] = body
+			}
+			stack := []*CFGNode{node}
+			for len(stack) > 0 {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if body[n] || (idom[n] == nil && n != cfg.Nodes[0]) {
+					continue
+				}
+				body[n] = true
+				stack = append(stack, n.Preds...)
+			}
+		}
+	}
+
+	var loops []*Loop
+	for header, body := range members {
+		loop := &Loop{Header: header}
+		for _, node := range cfg.Nodes {
+			if body[node] {
+				loop.Nodes = append(loop.Nodes, node)
+			}
+		}
+		loops = append(loops, loop)
+	}
+	sort.Slice(loops, func(i, j int) bool { return loops[i].Header.ID < loops[j].Header.ID })
+
+	// The parent of a loop is the smallest other loop containing its header
+	for _, loop := range loops {
+		for _, other := range loops {
+			if other == loop || !members[other.Header][loop.Header] {
+				continue
+			}
+			if loop.Parent == nil || len(other.Nodes) < len(loop.Parent.Nodes) {
+				loop.Parent = other
+			}
+		}
+	}
+	return loops
+}
@@ -0,0 +1,30 @@
+package cfg
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"strings"
+)
+
+// DumpText writes g to w in a terse form meant for golden files: a line per
+// node, "<id> <kind> <label>", followed by a line per edge,
+// "<from> -> <to> <kind>", both in ID order. Labels are flattened onto one
+// line.
+func DumpText(w io.Writer, g *CFG) error {
+	// Labels are printed from the AST alone, so no positions are needed
+	fset := token.NewFileSet()
+	var b strings.Builder
+	for _, node := range g.Nodes {
+		fmt.Fprintln(&b, strings.TrimSpace(fmt.Sprintf("%d %s %s", node.ID, node.Kind, flatLabel(node, fset))))
+	}
+	for _, node := range g.Nodes {
+		for _, edge := range node.Edges {
+			if succ := edge.To; succ != nil {
+				fmt.Fprintf(&b, "%d -> %d %s\n", node.ID, succ.ID, edge.Kind)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+
+	"cfglab/cfg"
+)
+
+// writeDiff writes how the graph of each selected function in files differs
+// from that of the function of the same name in oldFile, as lines of added
+// (+) and removed (-) nodes and edges under the function's name. Functions
+// whose graphs match are left out, and those missing from either side are
+// reported as such.
+func writeDiff(w io.Writer, fset *token.FileSet, oldFile *ast.File, files []*ast.File, selected func(*ast.FuncDecl) bool, opts cfg.Options) error {
+	oldDecls := make(map[string]*ast.FuncDecl)
+	var oldNames []string
+	for _, decl := range oldFile.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && selected(funcDecl) {
+			name := getFuncName(funcDecl, fset)
+			oldDecls[name] = funcDecl
+			oldNames = append(oldNames, name)
+		}
+	}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || !selected(funcDecl) {
+				continue
+			}
+			name := getFuncName(funcDecl, fset)
+			oldDecl, ok := oldDecls[name]
+			if !ok {
+				fmt.Fprintf(w, "%s: added\n", name)
+				continue
+			}
+			delete(oldDecls, name)
+			before, err := cfg.BuildCFGWithOptions(fset, oldDecl, opts)
+			if err != nil {
+				return err
+			}
+			after, err := cfg.BuildCFGWithOptions(fset, funcDecl, opts)
+			if err != nil {
+				return err
+			}
+			d := cfg.Diff(before, after, fset)
+			if d.Empty() {
+				continue
+			}
+			fmt.Fprintf(w, "%s:\n", name)
+			for _, node := range d.RemovedNodes {
+				fmt.Fprintf(w, "- node %s\n", node)
+			}
+			for _, node := range d.AddedNodes {
+				fmt.Fprintf(w, "+ node %s\n", node)
+			}
+			for _, edge := range d.RemovedEdges {
+				fmt.Fprintf(w, "- edge %s\n", edge)
+			}
+			for _, edge := range d.AddedEdges {
+				fmt.Fprintf(w, "+ edge %s\n", edge)
+			}
+		}
+	}
+	for _, name := range oldNames {
+		if _, ok := oldDecls[name]; ok {
+			fmt.Fprintf(w, "%s: removed\n", name)
+		}
+	}
+	return nil
+}
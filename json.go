@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"go/token"
+	"io"
+	"strings"
+
+	"cfglab/cfg"
+)
+
+// jsonGraph is the JSON form of a graph.
+type jsonGraph struct {
+	Name      string     `json:"name,omitempty"`
+	Signature string     `json:"signature,omitempty"`
+	Test      bool       `json:"test,omitempty"`
+	Nodes     []jsonNode `json:"nodes"`
+	Edges     []jsonEdge `json:"edges"`
+}
+
+type jsonNode struct {
+	ID     string       `json:"id"`
+	Kind   cfg.NodeKind `json:"kind"`
+	Label  string       `json:"label"`
+	Source string       `json:"source"`
+	// Closures are the graphs of the function literals in the node.
+	Closures []*jsonGraph `json:"closures,omitempty"`
+}
+
+type jsonEdge struct {
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Kind  cfg.EdgeKind `json:"kind"`
+	Label string       `json:"label,omitempty"`
+}
+
+// writeJSON writes graphs as a JSON array with an object per function.
+func writeJSON(w io.Writer, graphs []funcGraph, fset *token.FileSet, opts *options) error {
+	out := make([]*jsonGraph, len(graphs))
+	for i, fg := range graphs {
+		out[i] = newJSONGraph(fg.graph, fset, newNodeLabeler(opts))
+		out[i].Name = fg.name
+		out[i].Signature = fg.signature
+		out[i].Test = fg.test
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// newJSONGraph converts g to its JSON form, labelling nodes with label.
+func newJSONGraph(g *cfg.CFG, fset *token.FileSet, label func(*cfg.CFGNode, *token.FileSet) string) *jsonGraph {
+	jg := &jsonGraph{Nodes: []jsonNode{}, Edges: []jsonEdge{}}
+	for _, node := range g.Nodes {
+		jn := jsonNode{
+			ID:    node.Name(),
+			Kind:  node.Kind,
+			Label: strings.TrimSpace(label(node, fset)),
+		}
+		if node.Kind != cfg.KindEntry && node.Kind != cfg.KindExit && node.Kind != cfg.KindPanicExit {
+			jn.Source = cfg.NodeText(node.Stmt, fset)
+		}
+		for _, closure := range node.Closures {
+			jn.Closures = append(jn.Closures, newJSONGraph(closure, fset, label))
+		}
+		jg.Nodes = append(jg.Nodes, jn)
+		for _, edge := range node.Edges {
+			jg.Edges = append(jg.Edges, jsonEdge{
+				From:  node.Name(),
+				To:    getEdgeTarget(edge),
+				Kind:  edge.Kind,
+				Label: edge.Label,
+			})
+		}
+	}
+	return jg
+}
@@ -1,174 +1,530 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/importer"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"cfglab/cfg"
 )
 
+// options holds the command-line configuration.
+type options struct {
+	input        string
+	stdin        bool
+	build        bool
+	output       string
+	funcName     string
+	list         bool
+	diff         string
+	match        string
+	split        bool
+	unreachable  bool
+	blocks       bool
+	shortCircuit bool
+	splitDecls   bool
+	prune        bool
+	maxDepth     int
+	reachable    bool
+	noEntry      bool
+	rpo          bool
+	format       string
+	positions    bool
+	maxLabel     int
+	collapse     bool
+	legend       bool
+	loops        bool
+	maxComplex   int
+	types        bool
+	stats        bool
+	verify       bool
+	timeout      time.Duration
+	verbose      bool
+	tests        bool
+}
+
+// parseFlags parses the command-line arguments into options.
+func parseFlags(args []string) (*options, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet("cfglab", flag.ContinueOnError)
+	fs.StringVar(&opts.input, "input", "", "Go source file or package directory to read (default stdin)")
+	fs.BoolVar(&opts.stdin, "stdin", false, "read Go source from standard input, which is already the default without -input; an explicit alias for scripts and editor integrations")
+	fs.BoolVar(&opts.build, "build", false, "in a package directory, only read the files the build constraints select for the current GOOS and GOARCH")
+	fs.BoolVar(&opts.tests, "tests", false, "in a package directory, also read _test.go files, marking the titles of tests, benchmarks, fuzz tests and examples")
+	fs.StringVar(&opts.output, "output", "", "file to write the graph to (default stdout)")
+	fs.StringVar(&opts.funcName, "func", "", "only process the named function")
+	fs.BoolVar(&opts.list, "list", false, "list the functions in the input with their signature and line, without building graphs")
+	fs.StringVar(&opts.diff, "diff", "", "compare each function with the one of the same name in this older Go file, listing added and removed nodes and edges")
+	fs.StringVar(&opts.match, "match", "", "only process functions whose name matches this regexp")
+	fs.BoolVar(&opts.split, "split", false, "write one <func>.<format> file per function instead of a single output")
+	fs.BoolVar(&opts.unreachable, "unreachable", false, "report unreachable statements on stderr")
+	fs.BoolVar(&opts.reachable, "reachable", false, "leave out statements unreachable from the entry (list them with -unreachable)")
+	fs.BoolVar(&opts.noEntry, "no-entry", false, "leave out the synthetic entry node, rooting each graph at its first statement")
+	fs.BoolVar(&opts.rpo, "rpo", false, "number nodes in reverse postorder, roughly the order they run in")
+	fs.BoolVar(&opts.blocks, "blocks", false, "coalesce straight-line statements into basic blocks")
+	fs.IntVar(&opts.maxDepth, "maxdepth", cfg.DefaultMaxDepth, "fail on statements nested more deeply than this")
+	fs.BoolVar(&opts.splitDecls, "split-decls", false, "give each spec of a grouped var, const or type declaration its own node")
+	fs.BoolVar(&opts.prune, "prune-constants", false, "leave out the branch of an if with a constant true or false condition that is never taken, making it unreachable")
+	fs.BoolVar(&opts.shortCircuit, "shortcircuit", false, "split && and || conditions of if and for statements into a node per operand")
+	fs.StringVar(&opts.format, "format", "dot", "output format: dot, json, mermaid or svg (which needs Graphviz)")
+	fs.BoolVar(&opts.positions, "show-positions", false, "append the file:line of each node to its label")
+	fs.BoolVar(&opts.types, "types", false, "type-check the input to show the receiver type of method calls and tell which range loops are over channels")
+	fs.BoolVar(&opts.collapse, "collapse", false, "draw single-statement if bodies as a label on the if's true edge in DOT output")
+	fs.BoolVar(&opts.loops, "loops", false, "group the nodes of each loop in a shaded cluster in DOT output")
+	fs.BoolVar(&opts.legend, "legend", false, "add a legend of node shapes and colors to DOT output")
+	fs.IntVar(&opts.maxLabel, "maxlabel", 0, "truncate DOT node labels to this many characters, keeping the full text as a tooltip (0 for no limit)")
+	fs.BoolVar(&opts.stats, "stats", false, "report graph statistics of each function on stderr")
+	fs.IntVar(&opts.maxComplex, "maxcomplexity", 0, "exit with status 1 if any function's cyclomatic complexity exceeds this (0 for no limit)")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "give up if reading the input and building the graphs takes longer than this (0 for no limit)")
+	fs.BoolVar(&opts.verify, "verify", false, "compare each graph with golang.org/x/tools/go/cfg on stderr")
+	fs.BoolVar(&opts.verbose, "v", false, "log progress and statements that can't be graphed on stderr")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.stdin && opts.input != "" {
+		err := errors.New("-stdin and -input are mutually exclusive")
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	return opts, nil
+}
+
 func main() {
-	// Open the input file
+	opts, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	// Progress is only logged with -v, keeping default runs quiet
+	logger := log.New(io.Discard, "", 0)
+	if opts.verbose {
+		logger = log.New(os.Stderr, "cfglab: ", 0)
+	}
+
+	// Processing stops early on an interrupt or once the timeout runs out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	// Read the input file or package directory, or standard input when
+	// neither is given
 	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, "input.go", nil, parser.ParseComments)
+	var files []*ast.File
+	info, err := os.Stat(opts.input)
+	isDir := err == nil && info.IsDir()
+	if isDir && opts.build {
+		files, err = parseBuildDir(ctx, fset, opts.input, opts.tests)
+	} else if isDir {
+		files, err = parseDir(ctx, fset, opts.input, opts.tests)
+	} else {
+		var file *ast.File
+		file, err = parseFile(fset, opts.input)
+		files = append(files, file)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	logger.Printf("read %d files", len(files))
 
-	// Find the function declaration
-	for _, decl := range file.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if !ok {
-			continue
+	writeGraphs, ok := graphWriters[opts.format]
+	if !ok {
+		log.Fatalf("unknown format %q", opts.format)
+	}
+	var typesInfo *types.Info
+	if opts.types {
+		typesInfo = checkTypes(fset, files)
+	}
+	var match *regexp.Regexp
+	if opts.match != "" {
+		if match, err = regexp.Compile(opts.match); err != nil {
+			log.Fatal(err)
 		}
-		// Generate the control flow graph
-		cfg := generateCFG(funcDecl)
+	}
+	// selected reports whether -func and -match let a function through
+	selected := func(funcDecl *ast.FuncDecl) bool {
+		name := getDeclName(funcDecl, fset)
+		return (opts.funcName == "" || name == opts.funcName) && (match == nil || match.MatchString(name))
+	}
+	buildOpts := cfg.Options{Types: typesInfo, ShortCircuit: opts.shortCircuit, SplitDecls: opts.splitDecls, PruneConstants: opts.prune, MaxDepth: opts.maxDepth}
+	if opts.verbose {
+		buildOpts.Logger = logger
+	}
 
-		// Write the CFG to an output file in DOT format
-		f, err := os.Create("output.dot")
+	if opts.diff != "" {
+		oldFile, err := parseFile(fset, opts.diff)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer f.Close()
+		if err := writeDiff(os.Stdout, fset, oldFile, files, selected, buildOpts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-		fmt.Fprintln(f, "digraph CFG {")
-		for _, node := range cfg.Nodes {
-			// Assign shapes based on node kind
-			shape := "box" // default shape
-			if node.Kind == "entry" {
-				shape = "diamond"
+	// Build the graph of each function declaration
+	var graphs []funcGraph
+	tooComplex := false
+	for _, file := range files {
+		// Functions from a directory are namespaced by the file they're in
+		namespace := ""
+		if isDir {
+			namespace = strings.TrimSuffix(filepath.Base(fset.Position(file.Package).Filename), ".go") + "."
+		}
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if !selected(funcDecl) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				log.Fatal(err)
+			}
+			name := namespace + getFuncName(funcDecl, fset)
+			if opts.list {
+				fmt.Printf("%s\t%s\t%d\n", name, getFuncSignature(funcDecl, fset), fset.Position(funcDecl.Pos()).Line)
+				continue
+			}
+			// Generate the control flow graph
+			logger.Printf("building %s", name)
+			g, err := cfg.BuildCFGWithOptions(fset, funcDecl, buildOpts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if opts.unreachable {
+				for _, node := range cfg.Unreachable(g) {
+					fmt.Fprintf(os.Stderr, "%s: unreachable: %s\n", fset.Position(node.Stmt.Pos()), cfg.NodeLabel(node, fset))
+				}
+			}
+			if opts.reachable {
+				g = g.Reachable()
+			}
+			if opts.verify {
+				verifyCFG(os.Stderr, name, funcDecl, g)
+			}
+			if n := cfg.Complexity(g); opts.maxComplex > 0 && n > opts.maxComplex {
+				fmt.Fprintf(os.Stderr, "%s: complexity %d exceeds %d\n", name, n, opts.maxComplex)
+				tooComplex = true
+			}
+			if opts.noEntry {
+				g = g.WithoutEntry()
+			}
+			if opts.rpo {
+				g = g.InReversePostOrder()
+			}
+			if opts.blocks {
+				g = g.BasicBlocks()
+			}
+			if opts.stats {
+				stats := cfg.Stats(g)
+				fmt.Fprintf(os.Stderr, "%s: nodes=%d edges=%d conditionals=%d loops=%d max-loop-depth=%d\n",
+					name, stats.Nodes, stats.Edges, stats.Conditionals, stats.Loops, stats.MaxLoopDepth)
 			}
-			fmt.Fprintf(f, "  %s [label=\"%s\", shape=\"%s\"];\n", getNodeID(node), getSourceString(node.Stmt, fset), shape)
+			graphs = append(graphs, funcGraph{name: name, signature: getFuncSignature(funcDecl, fset), test: isTestFunc(funcDecl, fset), graph: g})
 		}
-		for _, node := range cfg.Nodes {
-			for _, edge := range node.Edges {
-				fmt.Fprintf(f, "  %s -> %s;\n", getNodeID(node), getNodeIDByStmt(edge.Stmt, cfg.Nodes))
+	}
+	if opts.list {
+		return
+	}
+
+	if opts.split {
+		for _, fg := range graphs {
+			logger.Printf("writing %s", fg.name+"."+opts.format)
+			if err := writeGraphsFile(fg.name+"."+opts.format, writeGraphs, []funcGraph{fg}, fset, opts); err != nil {
+				log.Fatal(err)
 			}
 		}
-		fmt.Fprintln(f, "}")
+	} else if opts.output != "" {
+		logger.Printf("writing %d graphs to %s", len(graphs), opts.output)
+		if err := writeGraphsFile(opts.output, writeGraphs, graphs, fset, opts); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := writeGraphs(os.Stdout, graphs, fset, opts); err != nil {
+		log.Fatal(err)
+	}
+	if tooComplex {
+		os.Exit(1)
+	}
+}
+
+// parseFile parses the Go source file at path, or standard input when path is
+// empty.
+func parseFile(fset *token.FileSet, path string) (*ast.File, error) {
+	name, src := "<stdin>", []byte(nil)
+	var err error
+	if path != "" {
+		name = path
+		src, err = os.ReadFile(path)
+	} else {
+		src, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return parser.ParseFile(fset, name, src, parser.ParseComments)
 }
 
-func getNodeID(node *CFGNode) string {
-	if node.Stmt == nil {
-		return "entry"
+// parseDir parses the Go source files in dir, skipping test files unless tests
+// is set. It stops with ctx.Err() if ctx is done before all files are read.
+func parseDir(ctx context.Context, fset *token.FileSet, dir string, tests bool) ([]*ast.File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || (!tests && strings.HasSuffix(name, "_test.go")) {
+			continue
+		}
+		file, err := parseFile(fset, filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
 	}
-	return fmt.Sprintf("node%d", node.Stmt.Pos())
+	return files, nil
 }
 
-func getNodeIDByStmt(stmt ast.Stmt, nodes []*CFGNode) string {
-	for _, node := range nodes {
-		if node.Stmt == stmt {
-			return getNodeID(node)
+// parseBuildDir parses the Go source files in dir that go/build selects for the
+// current build context, going by build constraints and _GOOS and _GOARCH file
+// name suffixes. Test files are skipped unless tests is set, as in parseDir.
+func parseBuildDir(ctx context.Context, fset *token.FileSet, dir string, tests bool) ([]*ast.File, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := append(pkg.GoFiles, pkg.CgoFiles...)
+	if tests {
+		names = append(append(names, pkg.TestGoFiles...), pkg.XTestGoFiles...)
+	}
+	sort.Strings(names)
+	var files []*ast.File
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
+		file, err := parseFile(fset, filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
 	}
-	return ""
+	return files, nil
 }
 
-type CFGNode struct {
-	Stmt  ast.Stmt
-	Kind  string
-	Edges []*CFGEdge
+// funcGraph is the graph of a function along with the name it is output under
+// and the signature it is titled with.
+type funcGraph struct {
+	name      string
+	signature string
+	// test is set for the tests, benchmarks, fuzz tests and examples go test
+	// runs.
+	test  bool
+	graph *cfg.CFG
 }
 
-type CFGEdge struct {
-	Stmt ast.Stmt
-	Kind string
+// getGraphTitle returns the title of fg: its signature, marked for a test.
+func getGraphTitle(fg funcGraph) string {
+	if fg.test {
+		return "[test] " + fg.signature
+	}
+	return fg.signature
 }
 
-type CFG struct {
-	Nodes []*CFGNode
+// isTestFunc reports whether funcDecl is a function go test runs: a TestXxx,
+// BenchmarkXxx, FuzzXxx or ExampleXxx function in a _test.go file.
+func isTestFunc(funcDecl *ast.FuncDecl, fset *token.FileSet) bool {
+	if funcDecl.Recv != nil || funcDecl.Name == nil || !strings.HasSuffix(fset.Position(funcDecl.Pos()).Filename, "_test.go") {
+		return false
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		rest, ok := strings.CutPrefix(funcDecl.Name.Name, prefix)
+		if !ok {
+			continue
+		}
+		// As with go test, TestMain and Testify aren't tests, but Test and
+		// Test_x are
+		if rest == "" || !unicode.IsLower([]rune(rest)[0]) {
+			return funcDecl.Name.Name != "TestMain"
+		}
+	}
+	return false
 }
 
-func generateCFG(funcDecl *ast.FuncDecl) *CFG {
-	cfg := &CFG{Nodes: []*CFGNode{}}
-	nodeMap := make(map[ast.Stmt]*CFGNode)
+// graphWriter writes graphs in an output format, styled as opts asks.
+type graphWriter func(w io.Writer, graphs []funcGraph, fset *token.FileSet, opts *options) error
 
-	// Create a node for the function entry point
-	entryNode := &CFGNode{Stmt: nil, Kind: "entry"}
-	cfg.Nodes = append(cfg.Nodes, entryNode)
-	nodeMap[entryNode.Stmt] = entryNode
+// graphWriters maps each output format to the function writing graphs in it.
+var graphWriters = map[string]graphWriter{
+	"dot":     writeDOT,
+	"json":    writeJSON,
+	"mermaid": writeMermaid,
+	"svg":     writeSVG,
+}
 
-	// Create nodes for each statement in the function body
-	for _, stmt := range funcDecl.Body.List {
-		createCFGNode(stmt, entryNode, cfg, nodeMap)
+// writeGraphsFile writes graphs with writeGraphs to the file at path.
+func writeGraphsFile(path string, writeGraphs graphWriter, graphs []funcGraph, fset *token.FileSet, opts *options) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
 	}
-
-	return cfg
+	if err := writeGraphs(f, graphs, fset, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func createCFGNode(stmt ast.Stmt, parentNode *CFGNode, cfg *CFG, nodeMap map[ast.Stmt]*CFGNode) {
-	var node *CFGNode
-	switch stmt := stmt.(type) {
-	case *ast.ExprStmt:
-		node = &CFGNode{Stmt: stmt, Kind: "expr"}
-	case *ast.ReturnStmt:
-		node = &CFGNode{Stmt: stmt, Kind: "return"}
-	case *ast.IfStmt:
-		node = &CFGNode{Stmt: stmt, Kind: "if"}
-		// Create nodes for the if statement's branches
-		for _, branch := range stmt.Body.List {
-			createCFGNode(branch, node, cfg, nodeMap)
-		}
-		// Handle the else branch if present
-		if stmt.Else != nil {
-			createCFGNode(stmt.Else, node, cfg, nodeMap)
-		}
-	case *ast.ForStmt:
-		node = &CFGNode{Stmt: stmt, Kind: "for"}
-		// Create nodes for the loop body
-		for _, bodyStmt := range stmt.Body.List {
-			createCFGNode(bodyStmt, node, cfg, nodeMap)
-		}
-		// Add back edge for the loop
-		node.Edges = append(node.Edges, &CFGEdge{Stmt: node.Stmt, Kind: "loop"})
-	case *ast.RangeStmt:
-		node = &CFGNode{Stmt: stmt, Kind: "range"}
-		// Create nodes for the loop body
-		for _, bodyStmt := range stmt.Body.List {
-			createCFGNode(bodyStmt, node, cfg, nodeMap)
+// getFuncName returns the name of a function, qualified by the receiver's
+// type name for methods, e.g. "Server.Handle".
+func getFuncName(funcDecl *ast.FuncDecl, fset *token.FileSet) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return getDeclName(funcDecl, fset)
+	}
+	recv := funcDecl.Recv.List[0].Type
+	for {
+		switch t := recv.(type) {
+		case *ast.StarExpr:
+			recv = t.X
+			continue
+		case *ast.IndexExpr:
+			recv = t.X
+			continue
+		case *ast.IndexListExpr:
+			recv = t.X
+			continue
 		}
-		// Add back edge for the range loop
-		node.Edges = append(node.Edges, &CFGEdge{Stmt: node.Stmt, Kind: "loop"})
-	default:
-		log.Printf("unsupported statement type: %T", stmt)
-		return
+		break
 	}
+	return types.ExprString(recv) + "." + getDeclName(funcDecl, fset)
+}
 
-	// Append the node to the CFG and create the edge
-	cfg.Nodes = append(cfg.Nodes, node)
-	nodeMap[stmt] = node
-	parentNode.Edges = append(parentNode.Edges, &CFGEdge{Stmt: stmt, Kind: "next"})
+// getFuncSignature returns the declaration of a function without its body,
+// e.g. "func (s *Server) Handle(w http.ResponseWriter, r *http.Request) error".
+// Type parameters are kept, as in "func Map[T any](xs []T, f func(T) T) []T".
+func getFuncSignature(funcDecl *ast.FuncDecl, fset *token.FileSet) string {
+	name := funcDecl.Name
+	if name == nil {
+		name = ast.NewIdent(getDeclName(funcDecl, fset))
+	}
+	return cfg.NodeText(&ast.FuncDecl{Recv: funcDecl.Recv, Name: name, Type: funcDecl.Type}, fset)
+}
+
+// getDeclName returns the name a function declaration declares, or
+// "func@<position>" for a malformed declaration without one, as generated or
+// partial ASTs may have.
+func getDeclName(funcDecl *ast.FuncDecl, fset *token.FileSet) string {
+	if funcDecl.Name == nil {
+		return fmt.Sprintf("func@%s", fset.Position(funcDecl.Pos()))
+	}
+	return funcDecl.Name.Name
 }
 
-func getSourceString(stmt ast.Stmt, fset *token.FileSet) string {
-	if stmt == nil {
-		return ""
+// writeDOT writes graphs as a single DOT graph, with a cluster per function.
+func writeDOT(w io.Writer, graphs []funcGraph, fset *token.FileSet, opts *options) error {
+	clusters := make([]cfg.DOTCluster, len(graphs))
+	for i, fg := range graphs {
+		clusters[i] = cfg.DOTCluster{ID: getGraphID(fg.name), Title: getGraphTitle(fg), Graph: fg.graph}
 	}
+	return newDOTWriter(opts).WriteClusters(w, clusters, fset)
+}
 
-	var endPos int
+// newDOTWriter returns a DOT writer labelling and drawing nodes as opts asks.
+func newDOTWriter(opts *options) *cfg.DOTWriter {
+	return &cfg.DOTWriter{
+		Label:        newNodeLabeler(opts),
+		MaxLabel:     opts.maxLabel,
+		CollapseIfs:  opts.collapse,
+		Legend:       opts.legend,
+		LoopClusters: opts.loops,
+	}
+}
 
-	pos := stmt.Pos()
-	file := fset.File(pos)
-	line := file.Line(pos)
-	startPos := file.LineStart(line)
-	fileContent, _ := os.ReadFile(file.Name())
-	startOffset := int(file.Offset(startPos))
+// checkTypes type-checks files as a single package, importing dependencies
+// from source. Type errors are ignored, leaving whatever could be resolved.
+func checkTypes(fset *token.FileSet, files []*ast.File) *types.Info {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	if len(files) == 0 {
+		return info
+	}
+	conf.Check(files[0].Name.Name, fset, files, info)
+	return info
+}
 
-	for i := startOffset; i < len(fileContent); i++ {
-		if fileContent[i] == '\n' {
-			endPos = i
-			break
+// getGraphID turns a function name into an identifier usable in node IDs.
+func getGraphID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
 		}
-	}
+		return '_'
+	}, name)
+}
 
-	if endPos == 0 {
-		endPos = len(fileContent)
+func getEdgeTarget(edge *cfg.CFGEdge) string {
+	if edge.To != nil {
+		return edge.To.Name()
 	}
+	return ""
+}
 
-	lineBytes := fileContent[startOffset:endPos]
+// newNodeLabeler returns the function labelling nodes as opts asks, with
+// getNodeLabel.
+func newNodeLabeler(opts *options) func(node *cfg.CFGNode, fset *token.FileSet) string {
+	return func(node *cfg.CFGNode, fset *token.FileSet) string {
+		return getNodeLabel(node, fset, opts.positions)
+	}
+}
 
-	return string(lineBytes)
+// getNodeLabel returns cfg.NodeLabel of node, followed by its file:line when
+// positions is set.
+func getNodeLabel(node *cfg.CFGNode, fset *token.FileSet, positions bool) string {
+	// Each statement of a basic block gets its own position
+	if len(node.Block) > 0 {
+		return cfg.BlockLabel(node, func(member *cfg.CFGNode) string {
+			return getNodeLabel(member, fset, positions)
+		})
+	}
+	label := cfg.NodeLabel(node, fset)
+	if positions && node.Stmt != nil && node.Kind != cfg.KindExit && node.Kind != cfg.KindPanicExit {
+		pos := fset.Position(node.Stmt.Pos())
+		label = fmt.Sprintf("%s (%s:%d)", strings.TrimRight(label, " \t\n"), filepath.Base(pos.Filename), pos.Line)
+	}
+	return label
 }
@@ -0,0 +1,769 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cfglab/cfg"
+)
+
+// parseTestFile parses src, prefixed with a package clause, as source.go.
+func parseTestFile(t *testing.T, src string) (*token.FileSet, *ast.File) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "source.go", "package p\n\n"+src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fset, file
+}
+
+// getTestFunc returns the first function declared in file.
+func getTestFunc(t *testing.T, file *ast.File) *ast.FuncDecl {
+	t.Helper()
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			return funcDecl
+		}
+	}
+	t.Fatal("no function in source")
+	return nil
+}
+
+func TestVerifyCFG(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", "func f() {}", "ok"},
+		{"if and loop", "func f(c bool, n int) {\n\tif c {\n\t\ta()\n\t}\n\tfor i := 0; i < n; i++ {\n\t\tb()\n\t}\n}", "ok"},
+		{"for", "func f(n int) {\n\tfor i := 0; i < n; i++ {\n\t\ta()\n\t}\n}", "ok"},
+		{"range", "func f(m []int) {\n\tfor _, v := range m {\n\t\ta(v)\n\t}\n}", "ok"},
+		{"switch", "func f(x int) {\n\tswitch x {\n\tcase 1:\n\t\ta()\n\tcase 2:\n\t\tb()\n\t}\n}", "ok"},
+		{"dead statements", "func f() {\n\treturn\n\ta()\n}", "ok"},
+		{"empty bodies", "func f(c bool, m []int) {\n\tif c {\n\t}\n\tfor range m {\n\t}\n}", "ok"},
+		{"select", "func f(a, b chan int) {\n\tselect {\n\tcase <-a:\n\tcase v := <-b:\n\t\tc(v)\n\t}\n}", "ok"},
+		{"labels", "func f(n int) {\nouter:\n\tfor i := 0; i < n; i++ {\n\t\tfor {\n\t\t\tif i > 2 {\n\t\t\t\tcontinue outer\n\t\t\t}\n\t\t\tbreak outer\n\t\t}\n\t}\n}", "ok"},
+		{"panic", "func f(c bool) {\n\tif c {\n\t\tpanic(\"x\")\n\t}\n\ta()\n}", "ok"},
+		// Like x/tools, the dead branch is kept unless pruned
+		{"constant condition", "func f() {\n\tif false {\n\t\ta()\n\t}\n}", "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset, file := parseTestFile(t, tt.src)
+			funcDecl := getTestFunc(t, file)
+			g, err := cfg.BuildCFG(fset, funcDecl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			verifyCFG(&buf, "f", funcDecl, g)
+			if !strings.HasPrefix(buf.String(), "f: verify "+tt.want+":") {
+				t.Errorf("got %q, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTypesNoFiles(t *testing.T) {
+	if info := checkTypes(token.NewFileSet(), nil); info == nil {
+		t.Error("got nil info")
+	}
+}
+
+func TestGetNodeLabelBlockPositions(t *testing.T) {
+	fset, file := parseTestFile(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t\tb()\n\t}\n}")
+	g, err := cfg.BuildCFG(fset, getTestFunc(t, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := getNodeLabel(g.BasicBlocks().Nodes[2], fset, true)
+	if want := "a() (source.go:5)\nb() (source.go:6)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.go")
+	if err := os.WriteFile(input, []byte("package p\n\nfunc f() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseFlags([]string{"-input", input, "-output", "out.dot", "-func", "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.input != input || opts.output != "out.dot" || opts.funcName != "f" {
+		t.Errorf("got input %q, output %q, func %q", opts.input, opts.output, opts.funcName)
+	}
+	file, err := parseFile(token.NewFileSet(), opts.input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getTestFunc(t, file).Name.Name; got != "f" {
+		t.Errorf("got function %q, want f", got)
+	}
+}
+
+func TestParseFlagsDefaults(t *testing.T) {
+	opts, err := parseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.input != "" || opts.output != "" || opts.funcName != "" || opts.format != "dot" {
+		t.Errorf("got input %q, output %q, func %q, format %q", opts.input, opts.output, opts.funcName, opts.format)
+	}
+}
+
+// buildTestGraphs builds the graph of each function declared in src.
+func buildTestGraphs(t *testing.T, src string) ([]funcGraph, *token.FileSet) {
+	t.Helper()
+	fset, file := parseTestFile(t, src)
+	var graphs []funcGraph
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		g, err := cfg.BuildCFG(fset, funcDecl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := getFuncName(funcDecl, fset)
+		graphs = append(graphs, funcGraph{name: name, signature: getFuncSignature(funcDecl, fset), test: isTestFunc(funcDecl, fset), graph: g})
+	}
+	return graphs, fset
+}
+
+func TestWriteDOTClusters(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func f() { a() }\n\nfunc g() { b() }")
+	var buf bytes.Buffer
+	if err := writeDOT(&buf, graphs, fset, &options{}); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{"subgraph cluster_f {", "f_node1 [label=\"a()\"", "subgraph cluster_g {", "g_node1 [label=\"b()\""} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "digraph"); n != 1 {
+		t.Errorf("got %d digraphs, want 1", n)
+	}
+}
+
+func TestWriteGraphsFileSplit(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func f() { a() }\n\nfunc g() { b() }")
+	dir := t.TempDir()
+	for _, fg := range graphs {
+		if err := writeGraphsFile(filepath.Join(dir, fg.name+".dot"), writeDOT, []funcGraph{fg}, fset, &options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, other := range map[string]string{"f": "g", "g": "f"} {
+		data, err := os.ReadFile(filepath.Join(dir, name+".dot"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data); !strings.Contains(got, "cluster_"+name) || strings.Contains(got, "cluster_"+other) {
+			t.Errorf("%s.dot:\n%s", name, got)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n}")
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, graphs, fset, &options{}); err != nil {
+		t.Fatal(err)
+	}
+	var got []jsonGraph
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d graphs, want 1", len(got))
+	}
+	if n := len(got[0].Nodes); n != 4 {
+		t.Errorf("got %d nodes, want 4", n)
+	}
+	if n := len(got[0].Edges); n != 4 {
+		t.Errorf("got %d edges, want 4", n)
+	}
+	want := jsonNode{ID: "node1", Kind: cfg.KindIf, Label: "c", Source: "if c {\n\ta()\n}"}
+	if node := got[0].Nodes[1]; node.ID != want.ID || node.Kind != want.Kind || node.Label != want.Label || node.Source != want.Source {
+		t.Errorf("got node %+v, want %+v", node, want)
+	}
+	if edge := got[0].Edges[1]; edge != (jsonEdge{From: "node1", To: "node2", Kind: cfg.EdgeTrue, Label: "true"}) {
+		t.Errorf("got edge %+v", edge)
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func f(c bool) {\n\tif c {\n\t\ta()\n\t}\n}")
+	var buf bytes.Buffer
+	if err := writeMermaid(&buf, graphs, fset, &options{}); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if !strings.HasPrefix(got, "flowchart TD\n") {
+		t.Errorf("output doesn't start with flowchart TD:\n%s", got)
+	}
+	for _, want := range []string{
+		`  f_entry(["entry"])`,
+		`  f_node1{"c"}`,
+		`  f_node2["a()"]`,
+		`  f_node1 -->|true| f_node2`,
+		`  f_node1 -->|false| f_exit`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestGetFuncSignature(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"func", "func f(a int, b string) (int, error) { return 0, nil }", "func f(a int, b string) (int, error)"},
+		{"method", "func (s *Server) Handle(w http.ResponseWriter, r *http.Request) error { return nil }", "func (s *Server) Handle(w http.ResponseWriter, r *http.Request) error"},
+		{"generic", "func Map[T, U any](xs []T, f func(T) U) []U {\n\tvar ys []U\n\tfor _, x := range xs {\n\t\tys = append(ys, f(x))\n\t}\n\treturn ys\n}", "func Map[T, U any](xs []T, f func(T) U) []U"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset, file := parseTestFile(t, tt.src)
+			if got := getFuncSignature(getTestFunc(t, file), fset); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDOTSignature(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func (s *Server) Handle(w, r int) error { return nil }")
+	var buf bytes.Buffer
+	if err := writeDOT(&buf, graphs, fset, &options{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "  subgraph cluster_Server_Handle {\n  label=\"func (s *Server) Handle(w, r int) error\";\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("output lacks %q:\n%s", want, buf.String())
+	}
+}
+
+// writeTestDir writes files, keyed by name, to a new temporary directory.
+func writeTestDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// getFileFuncs returns the names of the functions declared in files, in order.
+func getFileFuncs(files []*ast.File, fset *token.FileSet) []string {
+	var names []string
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+				names = append(names, getDeclName(funcDecl, fset))
+			}
+		}
+	}
+	return names
+}
+
+func TestParseDir(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{
+		"a.go":      "package p\n\nfunc fa() {}\n",
+		"b.go":      "package p\n\nfunc fb() {}\n",
+		"a_test.go": "package p\n\nfunc TestA() {}\n",
+		"notes.txt": "not Go",
+	})
+	tests := []struct {
+		tests bool
+		want  []string
+	}{
+		{false, []string{"fa", "fb"}},
+		{true, []string{"fa", "TestA", "fb"}},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		files, err := parseDir(context.Background(), fset, dir, tt.tests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := getFileFuncs(files, fset); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("tests=%t: got %q, want %q", tt.tests, got, tt.want)
+		}
+	}
+}
+
+// TestMain runs main in place of the tests when runMain re-executes the test
+// binary.
+func TestMain(m *testing.M) {
+	if os.Getenv("CFGLAB_RUN_MAIN") == "1" {
+		os.Args = append([]string{"cfglab"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args, feeding it stdin, and returns what it
+// writes to stdout and stderr along with its exit code.
+func runMain(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), "CFGLAB_RUN_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		code = exitErr.ExitCode()
+	}
+	return outBuf.String(), errBuf.String(), code
+}
+
+func TestMatch(t *testing.T) {
+	src := "package p\n\nfunc HandleA() {}\n\nfunc HandleB() {}\n\nfunc serve() {}\n"
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-match", "^Handle"}, []string{"HandleA", "HandleB"}},
+		{[]string{"-match", "B$"}, []string{"HandleB"}},
+		{[]string{"-func", "serve"}, []string{"serve"}},
+		{[]string{"-func", "HandleA", "-match", "B$"}, nil},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, src, append([]string{"-stdin", "-list"}, tt.args...)...)
+		if code != 0 {
+			t.Fatalf("%q: exit code %d: %s", tt.args, code, stderr)
+		}
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			if name, _, ok := strings.Cut(line, "\t"); ok {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%q: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	if _, stderr, code := runMain(t, "package p\n", "-stdin", "-match", "("); code != 1 || !strings.Contains(stderr, "missing closing )") {
+		t.Errorf("got exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestGetNodeLabelPositions(t *testing.T) {
+	fset, file := parseTestFile(t, "func f() {\n\ta()\n}")
+	g, err := cfg.BuildCFG(fset, getTestFunc(t, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		positions bool
+		want      []string
+	}{
+		{false, []string{"", "a()", ""}},
+		{true, []string{"", "a() (source.go:4)", ""}},
+	}
+	for _, tt := range tests {
+		for i, node := range g.Nodes {
+			if got := getNodeLabel(node, fset, tt.positions); got != tt.want[i] {
+				t.Errorf("positions=%t: node %d: got %q, want %q", tt.positions, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestStdin(t *testing.T) {
+	stdout, stderr, code := runMain(t, "package p\n\nfunc f() {\n\ta()\n}\n", "-stdin", "-format", "json")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var graphs []jsonGraph
+	if err := json.Unmarshal([]byte(stdout), &graphs); err != nil {
+		t.Fatal(err)
+	}
+	if len(graphs) != 1 || graphs[0].Name != "f" || len(graphs[0].Nodes) != 3 || graphs[0].Nodes[1].Label != "a()" {
+		t.Errorf("got %s", stdout)
+	}
+	// -stdin only spells out the default
+	if plain, _, _ := runMain(t, "package p\n\nfunc f() {\n\ta()\n}\n", "-format", "json"); plain != stdout {
+		t.Errorf("without -stdin got %s, want %s", plain, stdout)
+	}
+}
+
+func TestParseFlagsStdinAndInput(t *testing.T) {
+	if _, err := parseFlags([]string{"-stdin", "-input", "a.go"}); err == nil {
+		t.Error("got no error for -stdin with -input")
+	}
+}
+
+func TestMaxComplexity(t *testing.T) {
+	src := "package p\n\nfunc simple() {}\n\nfunc branchy(a, b bool) {\n\tif a {\n\t\tx()\n\t}\n\tif b {\n\t\ty()\n\t}\n}\n"
+	tests := []struct {
+		max    string
+		code   int
+		stderr string
+	}{
+		{"2", 1, "branchy: complexity 3 exceeds 2\n"},
+		{"3", 0, ""},
+		{"0", 0, ""},
+	}
+	for _, tt := range tests {
+		_, stderr, code := runMain(t, src, "-stdin", "-maxcomplexity", tt.max)
+		if code != tt.code || stderr != tt.stderr {
+			t.Errorf("-maxcomplexity %s: got exit code %d, stderr %q, want %d, %q", tt.max, code, stderr, tt.code, tt.stderr)
+		}
+	}
+}
+
+func TestNilFuncName(t *testing.T) {
+	fset, file := parseTestFile(t, "func f() {\n\ta()\n}")
+	funcDecl := getTestFunc(t, file)
+	funcDecl.Name = nil
+	name := getFuncName(funcDecl, fset)
+	if want := "func@source.go:3:1"; name != want {
+		t.Errorf("got name %q, want %q", name, want)
+	}
+	if want := "func func@source.go:3:1()"; getFuncSignature(funcDecl, fset) != want {
+		t.Errorf("got signature %q, want %q", getFuncSignature(funcDecl, fset), want)
+	}
+	if isTestFunc(funcDecl, fset) {
+		t.Error("nameless function is a test")
+	}
+	g, err := cfg.BuildCFG(fset, funcDecl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphs := []funcGraph{{name: name, signature: getFuncSignature(funcDecl, fset), graph: g}}
+	for format, writeGraphs := range graphWriters {
+		if format == "svg" {
+			continue
+		}
+		if err := writeGraphs(io.Discard, graphs, fset, &options{}); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+}
+
+func TestReachable(t *testing.T) {
+	src := "package p\n\nfunc f() {\n\ta()\n\treturn\n\tdead()\n}\n"
+	tests := []struct {
+		args     []string
+		wantDead bool
+	}{
+		{nil, true},
+		{[]string{"-reachable"}, false},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, src, append([]string{"-stdin"}, tt.args...)...)
+		if code != 0 {
+			t.Fatalf("%q: exit code %d: %s", tt.args, code, stderr)
+		}
+		if got := strings.Contains(stdout, "dead()"); got != tt.wantDead {
+			t.Errorf("%q: dead() in output is %t, want %t:\n%s", tt.args, got, tt.wantDead, stdout)
+		}
+	}
+	_, stderr, _ := runMain(t, src, "-stdin", "-reachable", "-unreachable")
+	if want := "<stdin>:6:2: unreachable: dead()\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+}
+
+func TestGetFuncName(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"func", "func f() {}", "f"},
+		{"method", "func (s *Server) Handle() {}", "Server.Handle"},
+		{"generic method", "func (l *List[T]) Push(v T) {}", "List.Push"},
+		{"generic method with two parameters", "func (m Map[K, V]) Get(k K) V { return m[k] }", "Map.Get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset, file := parseTestFile(t, tt.src)
+			if got := getFuncName(getTestFunc(t, file), fset); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDOTGeneric(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func Map[T any](xs []T, f func(T) T) []T {\n\tfor i, x := range xs {\n\t\txs[i] = f(x)\n\t}\n\treturn xs\n}")
+	var buf bytes.Buffer
+	if err := writeDOT(&buf, graphs, fset, &options{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "  label=\"func Map[T any](xs []T, f func(T) T) []T\";\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("output lacks %q:\n%s", want, buf.String())
+	}
+	if n := len(graphs[0].graph.Nodes); n != 5 {
+		t.Errorf("got %d nodes, want 5", n)
+	}
+}
+
+func TestList(t *testing.T) {
+	src := "package p\n\nfunc f(a int) error {\n\treturn nil\n}\n\nfunc (s *S) g() {}\n"
+	stdout, stderr, code := runMain(t, src, "-stdin", "-list")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "f\tfunc f(a int) error\t3\nS.g\tfunc (s *S) g()\t7\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	if _, err := exec.LookPath("dot"); err != nil {
+		t.Skip("Graphviz dot is not installed")
+	}
+	graphs, fset := buildTestGraphs(t, "func f() {\n\ta()\n}")
+	var buf bytes.Buffer
+	if err := writeSVG(&buf, graphs, fset, &options{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<svg") {
+		t.Errorf("got no SVG:\n%s", buf.String())
+	}
+}
+
+func TestWriteSVGWithoutDot(t *testing.T) {
+	t.Setenv("PATH", "")
+	graphs, fset := buildTestGraphs(t, "func f() {}")
+	err := writeSVG(io.Discard, graphs, fset, &options{})
+	if err == nil || !strings.Contains(err.Error(), "Graphviz") {
+		t.Errorf("got error %v, want one naming Graphviz", err)
+	}
+}
+
+func TestParseBuildDir(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{
+		"a.go":       "package p\n\nfunc fa() {}\n",
+		"b.go":       "//go:build ignore\n\npackage p\n\nfunc fb() {}\n",
+		"c_plan9.go": "package p\n\nfunc fc() {}\n",
+		"a_test.go":  "package p\n\nfunc TestA() {}\n",
+	})
+	tests := []struct {
+		tests bool
+		want  []string
+	}{
+		{false, []string{"fa"}},
+		{true, []string{"fa", "TestA"}},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		files, err := parseBuildDir(context.Background(), fset, dir, tt.tests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := getFileFuncs(files, fset); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("tests=%t: got %q, want %q", tt.tests, got, tt.want)
+		}
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	fset := token.NewFileSet()
+	oldFile, err := parser.ParseFile(fset, "old.go", "package p\n\nfunc f(c bool) {\n\tif c {\n\t\ta()\n\t}\n\tb()\n}\n\nfunc same() {}\n\nfunc g() {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFile, err := parser.ParseFile(fset, "new.go", "package p\n\nfunc same() {}\n\nfunc f(c bool) {\n\tif c {\n\t\ta()\n\t\treturn\n\t}\n\tb()\n}\n\nfunc h() {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	all := func(*ast.FuncDecl) bool { return true }
+	if err := writeDiff(&buf, fset, oldFile, []*ast.File{newFile}, all, cfg.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `f:
++ node [return] return
+- edge [expr] a() -seq-> [expr] b()
++ edge [expr] a() -seq-> [return] return
++ edge [return] return -return-> [exit]
+h: added
+g: removed
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseDirCanceled(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{
+		"a.go": "package p\n\nfunc fa() {}\n",
+		"b.go": "package p\n\nfunc fb() {}\n",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, parse := range map[string]func(context.Context, *token.FileSet, string, bool) ([]*ast.File, error){
+		"parseDir":      parseDir,
+		"parseBuildDir": parseBuildDir,
+	} {
+		files, err := parse(ctx, token.NewFileSet(), dir, false)
+		if err != context.Canceled || files != nil {
+			t.Errorf("%s: got %d files, error %v, want context.Canceled", name, len(files), err)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{"a.go": "package p\n\nfunc fa() {}\n"})
+	_, stderr, code := runMain(t, "", "-input", dir, "-timeout", "1ns")
+	if code != 1 || !strings.Contains(stderr, "context deadline exceeded") {
+		t.Errorf("got exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	src := "package p\n\nfunc f(x int) {\n\tswitch x {\n\tcase 1:\n\t\tfallthrough\n\t}\n}\n"
+	quietOut, stderr, code := runMain(t, src, "-stdin")
+	if code != 0 || stderr != "" {
+		t.Errorf("without -v: got exit code %d, stderr %q", code, stderr)
+	}
+	verboseOut, stderr, code := runMain(t, src, "-stdin", "-v")
+	if code != 0 {
+		t.Fatalf("with -v: exit code %d: %s", code, stderr)
+	}
+	for _, want := range []string{"cfglab: read 1 files\n", "cfglab: building f\n", "cfglab: fallthrough in the final case of a switch\n"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("with -v: stderr lacks %q: %q", want, stderr)
+		}
+	}
+	if verboseOut != quietOut {
+		t.Errorf("-v changed the output:\n%s\nwithout it:\n%s", verboseOut, quietOut)
+	}
+}
+
+func TestIsTestFunc(t *testing.T) {
+	tests := []struct {
+		filename string
+		src      string
+		want     bool
+	}{
+		{"a_test.go", "func TestA(t *testing.T) {}", true},
+		{"a_test.go", "func Test(t *testing.T) {}", true},
+		{"a_test.go", "func Test_a(t *testing.T) {}", true},
+		{"a_test.go", "func BenchmarkA(b *testing.B) {}", true},
+		{"a_test.go", "func FuzzA(f *testing.F) {}", true},
+		{"a_test.go", "func ExampleA() {}", true},
+		{"a_test.go", "func Testify() {}", false},
+		{"a_test.go", "func TestMain(m *testing.M) {}", false},
+		{"a_test.go", "func (s S) TestA(t *testing.T) {}", false},
+		{"a_test.go", "func helper() {}", false},
+		{"a.go", "func TestA(t *testing.T) {}", false},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, tt.filename, "package p\n\n"+tt.src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isTestFunc(getTestFunc(t, file), fset); got != tt.want {
+			t.Errorf("%s in %s: got %t, want %t", tt.src, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestTestsFlag(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{
+		"a.go":      "package p\n\nfunc fa() {}\n",
+		"a_test.go": "package p\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+	})
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"-tests"}, true},
+		{[]string{"-tests", "-build"}, true},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, "", append([]string{"-input", dir}, tt.args...)...)
+		if code != 0 {
+			t.Fatalf("%q: exit code %d: %s", tt.args, code, stderr)
+		}
+		if !strings.Contains(stdout, "subgraph cluster_a_fa {") {
+			t.Errorf("%q: output lacks fa:\n%s", tt.args, stdout)
+		}
+		if got := strings.Contains(stdout, "label=\"[test] func TestA(t *testing.T)\";"); got != tt.want {
+			t.Errorf("%q: TestA in output is %t, want %t:\n%s", tt.args, got, tt.want, stdout)
+		}
+	}
+}
+
+func TestWriteDOTOptions(t *testing.T) {
+	graphs, fset := buildTestGraphs(t, "func f() {\n\tlongName()\n}")
+	tests := []struct {
+		name string
+		opts *options
+		want string
+	}{
+		{"default", &options{}, "f_node1 [label=\"longName()\""},
+		{"positions", &options{positions: true}, "f_node1 [label=\"longName() (source.go:4)\""},
+		{"maxlabel", &options{maxLabel: 5}, "f_node1 [label=\"long…\""},
+		{"legend", &options{legend: true}, "subgraph cluster_legend {"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeDOT(&buf, graphs, fset, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("output lacks %q:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestPruneConstants(t *testing.T) {
+	src := "package p\n\nfunc f() {\n\tif false {\n\t\tdead()\n\t}\n}\n"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"-prune-constants"}, "<stdin>:5:3: unreachable: dead()\n"},
+	}
+	for _, tt := range tests {
+		_, stderr, code := runMain(t, src, append([]string{"-stdin", "-unreachable"}, tt.args...)...)
+		if code != 0 || stderr != tt.want {
+			t.Errorf("%q: got exit code %d, stderr %q, want %q", tt.args, code, stderr, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"strings"
+
+	"cfglab/cfg"
+)
+
+// writeMermaid writes graphs as a Mermaid flowchart, with a subgraph per
+// function.
+func writeMermaid(w io.Writer, graphs []funcGraph, fset *token.FileSet, opts *options) error {
+	fmt.Fprintln(w, "flowchart TD")
+	for _, fg := range graphs {
+		id := getGraphID(fg.name)
+		fmt.Fprintf(w, "  subgraph %s [\"%s\"]\n", id, escapeMermaidLabel(getGraphTitle(fg)))
+		writeMermaidGraph(w, fg.graph, fset, id+"_", newNodeLabeler(opts))
+		fmt.Fprintln(w, "  end")
+	}
+	return nil
+}
+
+// writeMermaidGraph writes the nodes and edges of g, prefixing node IDs with
+// prefix and labelling nodes with label.
+func writeMermaidGraph(w io.Writer, g *cfg.CFG, fset *token.FileSet, prefix string, label func(*cfg.CFGNode, *token.FileSet) string) {
+	for _, node := range g.Nodes {
+		text := escapeMermaidLabel(strings.TrimSpace(label(node, fset)))
+		// Assign shapes based on node kind
+		switch node.Kind {
+		case cfg.KindEntry, cfg.KindExit, cfg.KindPanicExit:
+			fmt.Fprintf(w, "  %s([\"%s\"])\n", prefix+node.Name(), node.Kind)
+		case cfg.KindIf, cfg.KindCond, cfg.KindFor, cfg.KindRange, cfg.KindRangeChan, cfg.KindSwitch, cfg.KindTypeSwitch, cfg.KindSelect:
+			fmt.Fprintf(w, "  %s{\"%s\"}\n", prefix+node.Name(), text)
+		default:
+			fmt.Fprintf(w, "  %s[\"%s\"]\n", prefix+node.Name(), text)
+		}
+	}
+	for _, node := range g.Nodes {
+		for _, edge := range node.Edges {
+			// Error returns are dotted to stand out from successful ones
+			arrow := "-->"
+			if edge.Kind == cfg.EdgeError {
+				arrow = "-.->"
+			}
+			if edge.Label != "" {
+				fmt.Fprintf(w, "  %s %s|%s| %s\n", prefix+node.Name(), arrow, escapeMermaidLabel(edge.Label), prefix+getEdgeTarget(edge))
+				continue
+			}
+			fmt.Fprintf(w, "  %s %s %s\n", prefix+node.Name(), arrow, prefix+getEdgeTarget(edge))
+		}
+	}
+	// Deferred calls run in LIFO order whenever the function returns or panics
+	for _, edge := range g.DeferredEdges() {
+		fmt.Fprintf(w, "  %s -.-> %s\n", prefix+edge.From.Name(), prefix+edge.To.Name())
+	}
+	// Function literal bodies hang off their node in a subgraph of their own
+	for _, node := range g.Nodes {
+		for i, closure := range node.Closures {
+			id := fmt.Sprintf("%s%s_%d", prefix, node.Name(), i)
+			title, edgeLabel := cfg.ClosureLabels(node, i)
+			fmt.Fprintf(w, "  subgraph %s [\"%s\"]\n", id, title)
+			writeMermaidGraph(w, closure, fset, id+"_", label)
+			fmt.Fprintln(w, "  end")
+			fmt.Fprintf(w, "  %s -.->|%s| %s\n", prefix+node.Name(), edgeLabel, id+"_"+closure.Nodes[0].Name())
+		}
+	}
+}
+
+// mermaidLabelEscaper replaces the characters that would end a quoted Mermaid
+// label or an edge label with their entity codes.
+var mermaidLabelEscaper = strings.NewReplacer(`"`, "#quot;", "|", "#124;", "\n", "<br>")
+
+// escapeMermaidLabel escapes s for use inside a Mermaid node or edge label.
+func escapeMermaidLabel(s string) string {
+	return mermaidLabelEscaper.Replace(s)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/token"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// dotTimeout bounds how long Graphviz may take to lay out the graphs.
+const dotTimeout = time.Minute
+
+// writeSVG writes graphs as an SVG image, rendering their DOT graph with the
+// dot command of Graphviz.
+func writeSVG(w io.Writer, graphs []funcGraph, fset *token.FileSet, opts *options) error {
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		return fmt.Errorf("svg output needs the Graphviz dot command: %w", err)
+	}
+	var src bytes.Buffer
+	if err := writeDOT(&src, graphs, fset, opts); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dotTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "-Tsvg")
+	cmd.Stdin = &src
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("dot timed out after %v", dotTimeout)
+		}
+		return fmt.Errorf("dot: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
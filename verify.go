@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+
+	xcfg "golang.org/x/tools/go/cfg"
+
+	"cfglab/cfg"
+)
+
+// verifyCFG compares the blocks of g, the graph of funcDecl, with those
+// golang.org/x/tools/go/cfg builds for the same function, writing the block
+// and edge counts of both to w. Both graphs are first reduced to the blocks
+// reachable from the entry, as described at flowGraph, so that only real
+// differences in control flow are reported. Constant conditions pruned by
+// Options.PruneConstants, which x/tools keeps, and conditions split by
+// Options.ShortCircuit show up as differences.
+func verifyCFG(w io.Writer, name string, funcDecl *ast.FuncDecl, g *cfg.CFG) {
+	blocks, edges := newFlowGraph(g).count()
+	body := funcDecl.Body
+	if body == nil {
+		body = &ast.BlockStmt{}
+	}
+	xBlocks, xEdges := newXFlowGraph(xcfg.New(body, func(call *ast.CallExpr) bool {
+		ident, ok := ast.Unparen(call.Fun).(*ast.Ident)
+		return !ok || ident.Name != "panic"
+	})).count()
+	status := "ok"
+	if blocks != xBlocks || edges != xEdges {
+		status = "differs"
+	}
+	fmt.Fprintf(w, "%s: verify %s: blocks=%d edges=%d, x/tools blocks=%d edges=%d\n", name, status, blocks, edges, xBlocks, xEdges)
+}
+
+// flowGraph is a bare graph of blocks that both graphs are reduced to before
+// being counted. Block 0 is the entry.
+//
+// The reductions follow the x/tools model. Returns and panics have no
+// successor, while falling off the end of the body leads to a block of its
+// own, the implicit return. A conditional has two successors, so switches and
+// selects test their cases one at a time. Blocks without statements, like the
+// joins after an if or the clauses of a switch, only pass control on.
+type flowGraph []flowBlock
+
+// flowBlock is a block of a flowGraph.
+type flowBlock struct {
+	succs []int
+	// empty is set for a block without statements of its own
+	empty bool
+}
+
+// addBlock adds a block with the given successors, returning its index.
+func (f *flowGraph) addBlock(succs ...int) int {
+	*f = append(*f, flowBlock{succs: succs})
+	return len(*f) - 1
+}
+
+// newFlowGraph reduces g to a flowGraph, a block per node. The exit node is the
+// implicit return, reached only by falling off the end of the body.
+func newFlowGraph(g *cfg.CFG) flowGraph {
+	index := make(map[*cfg.CFGNode]int, len(g.Nodes))
+	f := make(flowGraph, len(g.Nodes))
+	for i, node := range g.Nodes {
+		index[node] = i
+	}
+	// A range statement evaluates its operand in a block before the loop
+	// header, which only the loop's own edges skip
+	ranges := make(map[*cfg.CFGNode]int)
+	for i, node := range g.Nodes {
+		if node.Kind == cfg.KindRange || node.Kind == cfg.KindRangeChan {
+			ranges[node] = f.addBlock(i)
+		}
+	}
+	for i, node := range g.Nodes {
+		var succs []int
+		for _, edge := range node.Edges {
+			if edge.To == nil || edge.Kind == cfg.EdgeReturn || edge.Kind == cfg.EdgeError || edge.Kind == cfg.EdgePanic {
+				continue
+			}
+			to := index[edge.To]
+			if pre, ok := ranges[edge.To]; ok && edge.Kind != cfg.EdgeBack && edge.Kind != cfg.EdgeContinue {
+				to = pre
+			}
+			// Each expression of a case clause is tested in turn
+			tests := 1
+			if clause, ok := edge.To.Stmt.(*ast.CaseClause); ok && edge.Kind == cfg.EdgeCase {
+				tests = max(len(clause.List), 1)
+			}
+			for range tests {
+				succs = append(succs, to)
+			}
+		}
+		// A multiway branch becomes a chain of two-way tests, built from the end
+		for len(succs) > 2 {
+			last := len(succs) - 2
+			test := f.addBlock(append([]int(nil), succs[last:]...)...)
+			succs = append(succs[:last:last], test)
+		}
+		f[i] = flowBlock{succs: succs, empty: isEmptyNode(node)}
+	}
+	return f
+}
+
+// isEmptyNode reports whether node stands for no statement x/tools keeps in a
+// block: a label, a jump, a case clause, a loop without a condition or a
+// constant or type declaration.
+func isEmptyNode(node *cfg.CFGNode) bool {
+	switch node.Kind {
+	case cfg.KindLabel, cfg.KindGoto, cfg.KindBreak, cfg.KindContinue, cfg.KindFallthrough, cfg.KindCase:
+		return true
+	case cfg.KindFor:
+		// A loop without a condition tests nothing
+		return node.Stmt.(*ast.ForStmt).Cond == nil
+	case cfg.KindComm:
+		// Only a receive assigning its value leaves a statement in the clause
+		clause, _ := node.Stmt.(*ast.CommClause)
+		_, ok := clause.Comm.(*ast.AssignStmt)
+		return !ok
+	case cfg.KindDecl:
+		decl, _ := node.Stmt.(*ast.DeclStmt).Decl.(*ast.GenDecl)
+		return decl != nil && decl.Tok != token.VAR
+	}
+	return false
+}
+
+// newXFlowGraph reduces g to a flowGraph, leaving out the empty dead end a
+// select without a default clause has after its last case.
+func newXFlowGraph(g *xcfg.CFG) flowGraph {
+	f := make(flowGraph, len(g.Blocks))
+	for _, block := range g.Blocks {
+		f[block.Index].empty = len(block.Nodes) == 0
+		for _, succ := range block.Succs {
+			if len(succ.Nodes) == 0 && len(succ.Succs) == 0 {
+				continue
+			}
+			f[block.Index].succs = append(f[block.Index].succs, int(succ.Index))
+		}
+	}
+	return f
+}
+
+// target returns the block control reaches from b once it has passed through
+// the empty blocks that only lead on to another.
+func (f flowGraph) target(b int) int {
+	seen := make(map[int]bool)
+	for b != 0 && f[b].empty && len(f[b].succs) == 1 && !seen[b] {
+		seen[b] = true
+		b = f[b].succs[0]
+	}
+	return b
+}
+
+// count returns the number of blocks reachable from the entry and of the edges
+// between them, once empty blocks are passed through and each block that can
+// only be entered from a block with no other successor is merged into it.
+func (f flowGraph) count() (blocks, edges int) {
+	succs := make([][]int, len(f))
+	for b := range f {
+		for _, succ := range f[b].succs {
+			succs[b] = append(succs[b], f.target(succ))
+		}
+	}
+	live := make([]bool, len(f))
+	stack := []int{0}
+	for len(stack) > 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !live[b] {
+			live[b] = true
+			stack = append(stack, succs[b]...)
+		}
+	}
+	preds := make([]int, len(f))
+	for b := range f {
+		if !live[b] {
+			continue
+		}
+		blocks++
+		edges += len(succs[b])
+		for _, succ := range succs[b] {
+			preds[succ]++
+		}
+	}
+	for b := range f {
+		if live[b] && len(succs[b]) == 1 && succs[b][0] != b && preds[succs[b][0]] == 1 {
+			blocks--
+			edges--
+		}
+	}
+	return blocks, edges
+}